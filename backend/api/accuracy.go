@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/services"
+)
+
+// RecordPowerEstimates utfärdar en förbrukningsprognos från aktuell kvart till slutet av
+// morgondagen och sparar den, så att den senare kan jämföras med utfallet. Anropas från cron.
+func (a *API) RecordPowerEstimates() (int, error) {
+	now := time.Now()
+	issuedAt := now.Truncate(15 * time.Minute)
+	startOfToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	endOfTomorrow := startOfToday.Add(48 * time.Hour)
+	quarters := int(endOfTomorrow.Sub(issuedAt) / (15 * time.Minute))
+
+	estimates, _ := a.estimatePower(issuedAt, quarters)
+
+	// Prognoser sparas i 90 dagar
+	if err := a.db.SavePowerEstimates(issuedAt, estimates, issuedAt.AddDate(0, 0, -90)); err != nil {
+		return 0, err
+	}
+	return len(estimates), nil
+}
+
+// GetPowerEstimateAccuracy jämför sparade prognoser med uppmätt last i history och returnerar
+// MAE, bias och RMSE per prognoshorisont (?days=, default 14)
+func (a *API) GetPowerEstimateAccuracy(c *gin.Context) {
+	days := 14
+	if value := c.Query("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 90 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days måste vara mellan 1 och 90"})
+			return
+		}
+		days = n
+	}
+
+	to := time.Now().Truncate(15 * time.Minute)
+	from := to.AddDate(0, 0, -days)
+
+	estimates, err := a.db.GetIssuedPowerEstimates(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	history, err := a.db.GetHistory(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	horizons, overall := services.CalculateForecastAccuracy(estimates, services.MeasuredNetLoad(history))
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":     from,
+		"to":       to,
+		"overall":  overall,
+		"horizons": horizons,
+	})
+}
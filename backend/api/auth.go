@@ -0,0 +1,376 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// sessionCookie är kakan som webbgränssnittets inloggning sparas i
+const sessionCookie = "battery_session"
+
+// Nycklar i gin.Context som sätts av Authenticate
+const (
+	contextAuthEnabled = "auth_enabled"
+	contextPrincipal   = "principal"
+)
+
+// LoginRequest är parametrar till POST /api/auth/login
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// UserRequest är parametrar till POST/PUT /api/auth/users. Tomt lösenord vid PUT behåller lösenordet.
+type UserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// TokenRequest är parametrar till POST /api/auth/tokens
+type TokenRequest struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// Authenticate tar reda på vem som gör förfrågan, från en Authorization: Bearer-nyckel (API-nyckel)
+// eller inloggningskakan. Behörighet kontrolleras av RequireRole.
+func (a *API) Authenticate(c *gin.Context) {
+	enabled, err := a.auth.Enabled()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Set(contextAuthEnabled, enabled)
+
+	token, fromHeader := requestToken(c)
+	if token == "" {
+		c.Next()
+		return
+	}
+
+	principal, err := a.auth.Authenticate(token)
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		// En utgången kaka ska inte hindra inloggning, och öppna sökvägar läses som anonym
+		// även med en ogiltig nyckel. Annars svarar en ogiltig nyckel alltid 401.
+		if !fromHeader || a.isPublicRequest(c) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Ogiltig eller utgången nyckel"})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Set(contextPrincipal, principal)
+	c.Next()
+}
+
+// RequireRole släpper bara igenom förfrågningar med minst rollen role. När autentisering är avstängd
+// är allt öppet, och sökvägar i AUTH_PUBLIC_PATHS får läsas av alla.
+func (a *API) RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !c.GetBool(contextAuthEnabled) {
+			c.Next()
+			return
+		}
+		if role == models.RoleViewer && a.isPublicRequest(c) {
+			c.Next()
+			return
+		}
+
+		principal := requestPrincipal(c)
+		if principal == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Inloggning krävs"})
+			return
+		}
+		if !services.HasRole(principal, role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Kräver rollen " + role})
+			return
+		}
+		c.Next()
+	}
+}
+
+// isPublicRequest avgör om förfrågan läser en sökväg i AUTH_PUBLIC_PATHS
+func (a *API) isPublicRequest(c *gin.Context) bool {
+	return c.Request.Method == http.MethodGet && a.auth.IsPublic(c.FullPath())
+}
+
+// requestToken hämtar nyckeln från Authorization-huvudet eller inloggningskakan.
+// fromHeader är true om den kom från huvudet.
+func requestToken(c *gin.Context) (token string, fromHeader bool) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		token, _ = strings.CutPrefix(header, "Bearer ")
+		return strings.TrimSpace(token), true
+	}
+	token, _ = c.Cookie(sessionCookie)
+	return token, false
+}
+
+// requestPrincipal returnerar vem som gjort förfrågan, nil om okänt
+func requestPrincipal(c *gin.Context) *models.Principal {
+	if value, ok := c.Get(contextPrincipal); ok {
+		return value.(*models.Principal)
+	}
+	return nil
+}
+
+// requestAuthor är namnet som sparas som författare till schemaversioner och manuella lägen:
+// den inloggade användaren eller API-nyckeln, annars X-Author
+func requestAuthor(c *gin.Context) string {
+	if principal := requestPrincipal(c); principal != nil {
+		return principal.Name
+	}
+	return c.GetHeader("X-Author")
+}
+
+// Login loggar in i webbgränssnittet och sätter inloggningskakan
+func (a *API) Login(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+
+	token, user, expires, err := a.auth.Login(req.Username, req.Password)
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(sessionCookie, token, int(time.Until(expires).Seconds()), "/", "", c.Request.TLS != nil, true)
+	c.JSON(http.StatusOK, gin.H{
+		"user":    user,
+		"expires": expires,
+	})
+}
+
+// Logout avslutar inloggningen
+func (a *API) Logout(c *gin.Context) {
+	if token, err := c.Cookie(sessionCookie); err == nil {
+		if err := a.auth.Logout(token); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(sessionCookie, "", -1, "/", "", c.Request.TLS != nil, true)
+	c.JSON(http.StatusOK, gin.H{"message": "Utloggad"})
+}
+
+// GetAuthStatus returnerar om inloggning krävs och vem som är inloggad
+func (a *API) GetAuthStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":   c.GetBool(contextAuthEnabled),
+		"principal": requestPrincipal(c),
+	})
+}
+
+// GetUsers listar användare
+func (a *API) GetUsers(c *gin.Context) {
+	users, err := a.db.GetUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if users == nil {
+		users = []models.User{}
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// CreateUser lägger till en användare. Den första användaren måste vara admin,
+// och när den skapats krävs inloggning.
+func (a *API) CreateUser(c *gin.Context) {
+	var req UserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if strings.TrimSpace(req.Username) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Användarnamn saknas"})
+		return
+	}
+	if err := services.ValidateRole(req.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !c.GetBool(contextAuthEnabled) && req.Role != models.RoleAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Den första användaren måste vara admin"})
+		return
+	}
+	hash, err := services.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, _, err := a.db.GetUserCredentials(req.Username); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Användaren finns redan"})
+		return
+	}
+	user := models.User{Username: req.Username, Role: req.Role, CreatedAt: time.Now()}
+	if user.ID, err = a.auth.SaveUser(user, hash); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, user)
+}
+
+// UpdateUser byter roll och/eller lösenord för en användare
+func (a *API) UpdateUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltigt id"})
+		return
+	}
+	var req UserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if err := services.ValidateRole(req.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var hash string
+	if req.Password != "" {
+		if hash, err = services.HashPassword(req.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Role != models.RoleAdmin && !a.keepsAdmin(c, id) {
+		return
+	}
+
+	if _, err := a.auth.SaveUser(models.User{ID: id, Role: req.Role}, hash); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Användaren finns inte"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Användare uppdaterad", "id": id})
+}
+
+// DeleteUser tar bort en användare
+func (a *API) DeleteUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltigt id"})
+		return
+	}
+	if !a.keepsAdmin(c, id) {
+		return
+	}
+
+	if err := a.auth.DeleteUser(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Användaren finns inte"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Användare borttagen", "id": id})
+}
+
+// keepsAdmin svarar med fel och returnerar false om användaren id är den sista admin,
+// så att ingen kan låsa ute sig genom att ta bort eller nedgradera den
+func (a *API) keepsAdmin(c *gin.Context, id int) bool {
+	users, err := a.db.GetUsers()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	admins, isAdmin := 0, false
+	for _, u := range users {
+		if u.Role == models.RoleAdmin {
+			admins++
+			isAdmin = isAdmin || u.ID == id
+		}
+	}
+	if isAdmin && admins == 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Det måste finnas minst en admin"})
+		return false
+	}
+	return true
+}
+
+// GetAPITokens listar API-nycklar (utan själva nyckeln)
+func (a *API) GetAPITokens(c *gin.Context) {
+	tokens, err := a.db.GetAPITokens()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if tokens == nil {
+		tokens = []models.APIToken{}
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// CreateAPIToken skapar en API-nyckel, t.ex. för Home Assistant. Nyckeln visas bara i svaret.
+func (a *API) CreateAPIToken(c *gin.Context) {
+	var req TokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+
+	token, apiToken, err := a.auth.CreateAPIToken(req.Name, req.Role)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":     token,
+		"api_token": apiToken,
+	})
+}
+
+// DeleteAPIToken spärrar en API-nyckel
+func (a *API) DeleteAPIToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltigt id"})
+		return
+	}
+
+	if err := a.db.DeleteAPIToken(id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API-nyckeln finns inte"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API-nyckel borttagen", "id": id})
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/db"
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// newAuthTestRouter sätter upp autentiseringen som i main.go, med en enkel route per roll
+func newAuthTestRouter(t *testing.T, publicPaths ...string) (*API, *gin.Engine) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	database, err := db.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	a := &API{db: database, auth: services.NewAuthService(database, publicPaths)}
+
+	ok := func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"principal": requestPrincipal(c)}) }
+	router := gin.New()
+	apiRoutes := router.Group("/api", a.Authenticate)
+	apiRoutes.POST("/auth/login", a.Login)
+	apiRoutes.GET("/auth/me", a.GetAuthStatus)
+	apiRoutes.Group("", a.RequireRole(models.RoleViewer)).GET("/current-mode", ok)
+	apiRoutes.Group("", a.RequireRole(models.RoleViewer)).GET("/schedule", ok)
+	apiRoutes.Group("", a.RequireRole(models.RolePlanner)).PATCH("/schedule", ok)
+	admin := apiRoutes.Group("", a.RequireRole(models.RoleAdmin))
+	admin.GET("/auth/users", a.GetUsers)
+	admin.POST("/auth/users", a.CreateUser)
+	return a, router
+}
+
+func serve(router *gin.Engine, method, path, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func TestAuthDisabledUntilFirstAdmin(t *testing.T) {
+	_, router := newAuthTestRouter(t)
+
+	if w := serve(router, http.MethodPatch, "/api/schedule", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("planner route without admin: status %d, want 200", w.Code)
+	}
+	w := serve(router, http.MethodPost, "/api/auth/users", `{"username":"anna","password":"hemligt123","role":"viewer"}`, nil)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("first user as viewer: status %d, want 400", w.Code)
+	}
+	w = serve(router, http.MethodPost, "/api/auth/users", `{"username":"anna","password":"hemligt123","role":"admin"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("first admin: status %d, want 201: %s", w.Code, w.Body.String())
+	}
+
+	// Första admin slår på autentiseringen direkt, trots att Enabled sparas
+	if w := serve(router, http.MethodGet, "/api/current-mode", "", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("after first admin: status %d, want 401", w.Code)
+	}
+}
+
+func TestAuthSessionCookie(t *testing.T) {
+	a, router := newAuthTestRouter(t)
+	if err := a.auth.EnsureAdmin("anna", "hemligt123"); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(router, http.MethodPost, "/api/auth/login", `{"username":"anna","password":"fel-lösen"}`, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: status %d, want 401", w.Code)
+	}
+	w := serve(router, http.MethodPost, "/api/auth/login", `{"username":"anna","password":"hemligt123"}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: status %d, want 200", w.Code)
+	}
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "" || !cookie.HttpOnly {
+		t.Fatalf("login cookie = %+v, want an HttpOnly %s cookie", cookie, sessionCookie)
+	}
+
+	withCookie := http.Header{"Cookie": {cookie.String()}}
+	if w := serve(router, http.MethodGet, "/api/auth/users", "", withCookie); w.Code != http.StatusOK {
+		t.Fatalf("admin route with session: status %d, want 200", w.Code)
+	}
+
+	// En okänd kaka hindrar inte inloggning men ger ingen behörighet
+	unknown := http.Header{"Cookie": {sessionCookie + "=okand"}}
+	if w := serve(router, http.MethodPost, "/api/auth/login", `{"username":"anna","password":"hemligt123"}`, unknown); w.Code != http.StatusOK {
+		t.Fatalf("login with stale cookie: status %d, want 200", w.Code)
+	}
+	if w := serve(router, http.MethodGet, "/api/auth/users", "", unknown); w.Code != http.StatusUnauthorized {
+		t.Fatalf("admin route with stale cookie: status %d, want 401", w.Code)
+	}
+}
+
+func TestAuthBearerToken(t *testing.T) {
+	a, router := newAuthTestRouter(t)
+	if err := a.auth.EnsureAdmin("anna", "hemligt123"); err != nil {
+		t.Fatal(err)
+	}
+	token, created, err := a.auth.CreateAPIToken("home-assistant", models.RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bara hashen sparas, så nyckeln i klartext hittas inte i databasen
+	if _, err := a.db.GetAPITokenByHash(token); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("lookup by plaintext token: err = %v, want sql.ErrNoRows", err)
+	}
+
+	w := serve(router, http.MethodGet, "/api/auth/me", "", bearer(token))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"home-assistant"`) {
+		t.Fatalf("me with token: status %d, body %s", w.Code, w.Body.String())
+	}
+
+	tokens, err := a.db.GetAPITokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].ID != created.ID || tokens[0].LastUsed == nil {
+		t.Fatalf("tokens after use = %+v, want last_used set", tokens)
+	}
+
+	if w := serve(router, http.MethodGet, "/api/schedule", "", bearer("fel-nyckel")); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown token: status %d, want 401", w.Code)
+	}
+}
+
+func TestAuthRequireRole(t *testing.T) {
+	a, router := newAuthTestRouter(t)
+	if err := a.auth.EnsureAdmin("anna", "hemligt123"); err != nil {
+		t.Fatal(err)
+	}
+	tokens := make(map[string]string)
+	for _, role := range []string{models.RoleViewer, models.RolePlanner, models.RoleAdmin} {
+		token, _, err := a.auth.CreateAPIToken(role, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[role] = token
+	}
+
+	routes := []struct {
+		method, path string
+		role         string // Lägsta roll som krävs
+	}{
+		{http.MethodGet, "/api/schedule", models.RoleViewer},
+		{http.MethodPatch, "/api/schedule", models.RolePlanner},
+		{http.MethodGet, "/api/auth/users", models.RoleAdmin},
+	}
+	for _, route := range routes {
+		for role, token := range tokens {
+			want := http.StatusForbidden
+			if models.RoleLevels[role] >= models.RoleLevels[route.role] {
+				want = http.StatusOK
+			}
+			if w := serve(router, route.method, route.path, "", bearer(token)); w.Code != want {
+				t.Errorf("%s %s as %s: status %d, want %d", route.method, route.path, role, w.Code, want)
+			}
+		}
+		if w := serve(router, route.method, route.path, "", nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s anonymous: status %d, want 401", route.method, route.path, w.Code)
+		}
+	}
+}
+
+func TestAuthPublicPaths(t *testing.T) {
+	a, router := newAuthTestRouter(t, "/api/current-mode")
+	if err := a.auth.EnsureAdmin("anna", "hemligt123"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header http.Header
+		want   int
+	}{
+		{"public path anonymous", http.MethodGet, "/api/current-mode", nil, http.StatusOK},
+		{"public path with invalid key", http.MethodGet, "/api/current-mode", bearer("utgangen"), http.StatusOK},
+		{"other path anonymous", http.MethodGet, "/api/schedule", nil, http.StatusUnauthorized},
+		{"other path with invalid key", http.MethodGet, "/api/schedule", bearer("utgangen"), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(router, tt.method, tt.path, "", tt.header); w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
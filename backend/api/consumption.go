@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/services"
+)
+
+// ConsumptionFitRequest är parametrar till POST /api/consumption-model/fit
+type ConsumptionFitRequest struct {
+	Days int `json:"days"` // Antal dygn bakåt att anpassa mot, default 28
+}
+
+// GetConsumptionModel returnerar aktiv förbrukningsmodell med anpassningsfel.
+// model är null när den fasta temperaturkurvan används.
+func (a *API) GetConsumptionModel(c *gin.Context) {
+	model := a.consumption.Model()
+	active := "default"
+	if model != nil {
+		active = "fitted"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"active": active,
+		"model":  model,
+	})
+}
+
+// FitConsumptionModel anpassar en ny förbrukningsmodell mot uppmätt last och börjar använda den
+func (a *API) FitConsumptionModel(c *gin.Context) {
+	req := ConsumptionFitRequest{Days: 28}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+			return
+		}
+	}
+	if req.Days < 3 || req.Days > 365 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days måste vara mellan 3 och 365"})
+		return
+	}
+
+	to := time.Now().Truncate(15 * time.Minute)
+	from := to.AddDate(0, 0, -req.Days)
+
+	model, err := a.consumption.Fit(from, to)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInsufficientConsumptionData) {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"active": "fitted",
+		"model":  model,
+	})
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// EVPlanRequest är parametrar till POST /api/ev/plan
+type EVPlanRequest struct {
+	Cars   []services.EVChargeRequest `json:"cars"`
+	DryRun bool                       `json:"dry_run"`
+}
+
+// PlanEVCharging lägger in laddbox Garage (5) och Ute (6) på billigaste kvartarna före varje
+// bils avresa och slår ihop resultatet med sparat schema. Med dry_run sparas inget.
+func (a *API) PlanEVCharging(c *gin.Context) {
+	var req EVPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if c.Query("dry_run") == "true" {
+		req.DryRun = true
+	}
+	if len(req.Cars) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Inga bilar att planera"})
+		return
+	}
+	for i := range req.Cars {
+		if req.Cars[i].PowerKW == 0 {
+			req.Cars[i].PowerKW = a.chargerPowerKW(req.Cars[i].Mode)
+		}
+	}
+
+	now := time.Now()
+	startOfToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	endOfTomorrow := startOfToday.Add(48 * time.Hour)
+	currentQuarter := now.Truncate(15 * time.Minute)
+
+	prices, err := a.pricesWithTariff(currentQuarter, endOfTomorrow)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(prices) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Inga priser finns för resten av perioden"})
+		return
+	}
+
+	existing, err := a.db.GetSchedule()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	times := make([]time.Time, len(prices))
+	modes := make([]int, len(prices))
+	for i, p := range prices {
+		times[i] = p.Timestamp
+		modes[i] = services.ModeAt(existing, p.Timestamp)
+	}
+
+	planned, plans, err := services.PlanEVCharging(prices, modes, req.Cars)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Ersätt bara kvartar som har pris, och återställ sparat läge där priserna tar slut
+	merged := mergeQuarterModes(existing, times, planned)
+
+	if !req.DryRun {
+		if _, err := a.applySchedule(merged, a.scheduleRevision(c, models.ScheduleSourceEV)); err != nil {
+			writeScheduleError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dry_run":         req.DryRun,
+		"plans":           plans,
+		"merged_schedule": merged,
+	})
+}
+
+// chargerPowerKW läser laddboxens effekt från settings (ev_garage_power_kw, ev_outdoor_power_kw)
+func (a *API) chargerPowerKW(mode int) float64 {
+	if mode == 6 {
+		return a.floatSetting("ev_outdoor_power_kw", 11)
+	}
+	return a.floatSetting("ev_garage_power_kw", 11)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// eventKeepAlive är hur ofta en kommentar skickas så att proxys inte stänger en tyst anslutning
+const eventKeepAlive = 25 * time.Second
+
+// StreamEvents skickar händelser som server-sent events: lägesbyten (mode), SoC-avläsningar (soc),
+// nya priser (prices), sparade scheman (schedule) och manuellt läge (override).
+// Vid anslutning skickas aktuellt läge och SoC direkt.
+func (a *API) StreamEvents(c *gin.Context) {
+	events, unsubscribe := a.events.Subscribe()
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Stäng av buffring i nginx
+
+	now := time.Now()
+	c.SSEvent(services.EventMode, models.Event{Type: services.EventMode, Time: now, Data: a.scheduler.GetCurrentMode(now)})
+	if a.homeAssistant.Configured() {
+		if soc, changed, err := a.homeAssistant.GetSoC(); err == nil {
+			c.SSEvent(services.EventSoC, models.Event{
+				Type: services.EventSoC,
+				Time: now,
+				Data: models.BatterySoCResponse{Percentage: soc, Timestamp: changed},
+			})
+		}
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-events:
+			c.SSEvent(event.Type, event)
+			return true
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,36 +17,45 @@ import (
 
 type API struct {
 	db            *db.Database
-	entsoe        *services.EntsoeService
+	prices        *services.PriceService
 	pushover      *services.PushoverService
 	scheduler     *services.SchedulerService
 	smhi          *services.SMHIService
+	forecasts     *services.ForecastStore
 	homeAssistant *services.HomeAssistantService
+	peaks         *services.PeakTracker
+	consumption   *services.ConsumptionModelService
+	executor      *services.ModeExecutor // nil om lägen inte skickas till Home Assistant
+	events        *services.EventHub
+	auth          *services.AuthService
 }
 
 // NewAPI skapar en ny API-instans
-func NewAPI(database *db.Database, entsoe *services.EntsoeService, pushover *services.PushoverService, smhi *services.SMHIService, ha *services.HomeAssistantService) *API {
-	// Ladda befintligt schema från databasen
-	schedule, _ := database.GetSchedule()
-	scheduler := services.NewSchedulerService(schedule)
-
+func NewAPI(database *db.Database, prices *services.PriceService, pushover *services.PushoverService, smhi *services.SMHIService, forecasts *services.ForecastStore, ha *services.HomeAssistantService, peaks *services.PeakTracker, consumption *services.ConsumptionModelService, scheduler *services.SchedulerService, executor *services.ModeExecutor, events *services.EventHub, auth *services.AuthService) *API {
 	return &API{
 		db:            database,
-		entsoe:        entsoe,
+		prices:        prices,
 		pushover:      pushover,
 		scheduler:     scheduler,
 		smhi:          smhi,
+		forecasts:     forecasts,
 		homeAssistant: ha,
+		peaks:         peaks,
+		consumption:   consumption,
+		executor:      executor,
+		events:        events,
+		auth:          auth,
 	}
 }
 
-// GetPrices returnerar priser för idag och imorgon (192 kvartar)
+// GetPrices returnerar priser för idag och imorgon (192 kvartar), både spotpris (price)
+// och totalkostnad med skatt, nätavgift och påslag (total_price)
 func (a *API) GetPrices(c *gin.Context) {
 	now := time.Now()
 	startOfToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 	endOfTomorrow := startOfToday.Add(48 * time.Hour)
 
-	prices, err := a.db.GetPrices(startOfToday, endOfTomorrow)
+	prices, err := a.pricesWithTariff(startOfToday, endOfTomorrow)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -52,18 +64,51 @@ func (a *API) GetPrices(c *gin.Context) {
 	c.JSON(http.StatusOK, prices)
 }
 
-// GetSchedule returnerar aktuellt schema
+// GetSchedule returnerar aktuellt schema, eller brytpunkterna i ?from=&to= (RFC3339 eller YYYY-MM-DD)
+// plus brytpunkten som gäller vid from. ETag är senaste versionen och skickas tillbaka i If-Match
+// vid ändringar.
 func (a *API) GetSchedule(c *gin.Context) {
+	var from, to time.Time
+	var err error
+	if value := c.Query("from"); value != "" {
+		if from, err = parseTimeParam(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltigt from, använd RFC3339 eller YYYY-MM-DD"})
+			return
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if to, err = parseTimeParam(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltigt to, använd RFC3339 eller YYYY-MM-DD"})
+			return
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !to.After(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to måste vara efter from"})
+		return
+	}
+
+	// Versionen läses före schemat, så en samtidig ändring ger en för gammal ETag och aldrig en för ny
+	revisionID, err := a.db.GetLatestScheduleRevisionID()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	schedule, err := a.db.GetSchedule()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if !from.IsZero() || !to.IsZero() {
+		schedule = services.ScheduleBetween(schedule, from, to)
+	}
 
+	c.Header("ETag", scheduleETag(revisionID))
 	c.JSON(http.StatusOK, schedule)
 }
 
-// SaveSchedule sparar ett nytt schema
+// SaveSchedule sparar ett nytt schema. Med If-Match sparas det bara om schemat inte ändrats sedan dess.
+// Med ?from= (som vid GET) ersätts bara schemat från och med from och tidigare brytpunkter behålls,
+// så att en klient som hämtat ett filtrerat schema inte raderar historiken.
 func (a *API) SaveSchedule(c *gin.Context) {
 	var schedule []models.ScheduleChange
 
@@ -71,24 +116,159 @@ func (a *API) SaveSchedule(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
 		return
 	}
+	var from time.Time
+	if value := c.Query("from"); value != "" {
+		var err error
+		if from, err = parseTimeParam(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltigt from, använd RFC3339 eller YYYY-MM-DD"})
+			return
+		}
+	}
 
-	// Validera schemat
-	tempScheduler := services.NewSchedulerService(schedule)
-	if err := tempScheduler.ValidateSchedule(schedule); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	source := models.ScheduleSourceAPI
+	if c.GetHeader("X-Schedule-Source") == models.ScheduleSourceUI {
+		source = models.ScheduleSourceUI
 	}
 
-	// Spara till databas
-	if err := a.db.SaveSchedule(schedule); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	revisionID, err := a.modifySchedule(func(current []models.ScheduleChange) ([]models.ScheduleChange, error) {
+		if !from.IsZero() {
+			schedule = keepScheduleBefore(current, schedule, from)
+		}
+		return schedule, nil
+	}, a.scheduleRevision(c, source), c.GetHeader("If-Match"))
+	if err != nil {
+		writeScheduleError(c, err)
 		return
 	}
 
-	// Uppdatera scheduler
-	a.scheduler.UpdateSchedule(schedule)
+	c.Header("ETag", scheduleETag(revisionID))
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Schema sparat",
+		"revision": revisionID,
+		"warnings": a.scheduleWarnings(schedule),
+	})
+}
+
+// keepScheduleBefore ersätter current från och med from med changes. Brytpunkter före from i changes
+// (t.ex. brytpunkten som gäller vid from i ett filtrerat GET-svar) ignoreras, de i current behålls.
+func keepScheduleBefore(current, changes []models.ScheduleChange, from time.Time) []models.ScheduleChange {
+	var merged []models.ScheduleChange
+	for _, change := range current {
+		if change.Timestamp.Before(from) {
+			merged = append(merged, change)
+		}
+	}
+	for _, change := range changes {
+		if !change.Timestamp.Before(from) {
+			merged = append(merged, change)
+		}
+	}
+	return merged
+}
+
+// scheduleValidationError skiljer valideringsfel (400) från databasfel (500)
+type scheduleValidationError struct {
+	err error
+}
+
+func (e *scheduleValidationError) Error() string { return e.err.Error() }
+func (e *scheduleValidationError) Unwrap() error { return e.err }
 
-	c.JSON(http.StatusOK, gin.H{"message": "Schema sparat"})
+// schedulePreconditionError betyder att If-Match inte stämde med senaste versionen (412)
+type schedulePreconditionError struct {
+	etag string // Aktuell ETag
+}
+
+func (e *schedulePreconditionError) Error() string {
+	return fmt.Sprintf("schemat har ändrats (aktuell version %s), hämta det igen", e.etag)
+}
+
+// applySchedule validerar, sparar och aktiverar ett nytt schema som en ny version.
+// Används av alla vägar som ersätter schemat (UI, optimerare, effektplanering).
+// Sparning och byte i minnet sker atomärt i scheduler. Returnerar versionens ID.
+func (a *API) applySchedule(schedule []models.ScheduleChange, revision models.ScheduleRevision) (int, error) {
+	return a.modifySchedule(func([]models.ScheduleChange) ([]models.ScheduleChange, error) {
+		return schedule, nil
+	}, revision, "")
+}
+
+// modifySchedule räknar fram ett nytt schema från det aktiva och sparar det som applySchedule.
+// Är ifMatch satt kontrolleras den mot senaste versionen under samma lås som sparningen.
+// Fel från modify räknas som valideringsfel.
+func (a *API) modifySchedule(modify func([]models.ScheduleChange) ([]models.ScheduleChange, error), revision models.ScheduleRevision, ifMatch string) (int, error) {
+	var revisionID int
+	var saveErr, modifyErr error
+	err := a.scheduler.ModifySchedule(func(current []models.ScheduleChange) ([]models.ScheduleChange, error) {
+		if ifMatch != "" {
+			latest, err := a.db.GetLatestScheduleRevisionID()
+			if err != nil {
+				saveErr = err
+				return nil, err
+			}
+			if etag := scheduleETag(latest); !etagMatches(ifMatch, etag) {
+				modifyErr = &schedulePreconditionError{etag: etag}
+				return nil, modifyErr
+			}
+		}
+		schedule, err := modify(current)
+		if err != nil {
+			modifyErr = &scheduleValidationError{err: err}
+			return nil, modifyErr
+		}
+		return schedule, nil
+	}, func(sorted []models.ScheduleChange) error {
+		revisionID, saveErr = a.db.SaveSchedule(sorted, revision)
+		return saveErr
+	})
+	if err != nil {
+		if saveErr != nil {
+			return 0, saveErr
+		}
+		if modifyErr != nil {
+			return 0, modifyErr
+		}
+		return 0, &scheduleValidationError{err: err}
+	}
+
+	if a.executor != nil {
+		a.executor.Notify()
+	}
+	a.events.Publish(services.EventSchedule, gin.H{
+		"revision": revisionID,
+		"source":   revision.Source,
+		"author":   revision.Author,
+	})
+	return revisionID, nil
+}
+
+// scheduleRevision beskriver en ny schemaversion från en förfrågan. Författaren är den inloggade
+// användaren eller API-nyckeln, annars X-Author.
+func (a *API) scheduleRevision(c *gin.Context, source string) models.ScheduleRevision {
+	return models.ScheduleRevision{
+		Source: source,
+		Author: requestAuthor(c),
+	}
+}
+
+// writeScheduleError svarar med rätt statuskod för ett fel från applySchedule
+func writeScheduleError(c *gin.Context, err error) {
+	var ruleErr *services.ScheduleValidationError
+	if errors.As(err, &ruleErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "violations": ruleErr.Violations})
+		return
+	}
+	var validationErr *scheduleValidationError
+	if errors.As(err, &validationErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var preconditionErr *schedulePreconditionError
+	if errors.As(err, &preconditionErr) {
+		c.Header("ETag", preconditionErr.etag)
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 }
 
 // GetCurrentMode returnerar vilket läge som är aktivt just nu (för Home Assistant)
@@ -100,42 +280,86 @@ func (a *API) GetCurrentMode(c *gin.Context) {
 }
 
 // GetPowerEstimate returnerar prognosticerad förbrukning per kvart baserat på SMHI-temperatur
+// och anpassad förbrukningsmodell (eller den fasta temperaturkurvan), samt förväntad solel.
+// Väderprognosens ålder skickas i X-Forecast-headers.
 func (a *API) GetPowerEstimate(c *gin.Context) {
 	now := time.Now()
 	startOfToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 
-	// Hämta väderprognos från SMHI
-	forecasts, err := a.smhi.FetchForecast()
-	if err != nil {
-		fmt.Printf("Failed to fetch SMHI forecast, using fallback: %v\n", err)
+	estimates, status := a.estimatePower(startOfToday, 192) // 48 timmar * 4 kvartar
+
+	c.Header("X-Forecast-Source", status.Source)
+	c.Header("X-Forecast-Stale", strconv.FormatBool(status.Stale))
+	if !status.ApprovedTime.IsZero() {
+		c.Header("X-Forecast-Approved", status.ApprovedTime.Format(time.RFC3339))
+	}
+	if !status.FetchedAt.IsZero() {
+		c.Header("X-Forecast-Fetched", status.FetchedAt.Format(time.RFC3339))
 	}
+	c.JSON(http.StatusOK, estimates)
+}
+
+// GetForecast returnerar sparad väderprognos från SMHI och hur färsk den är
+func (a *API) GetForecast(c *gin.Context) {
+	points, status := a.forecasts.Forecast()
+	if points == nil {
+		points = []models.ForecastPoint{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   status,
+		"forecast": points,
+	})
+}
+
+// estimatePower beräknar förbruknings- och solelsprognos för ett antal kvartar från och med start.
+// Väderprognosen tas från cachen; bara om ingen prognos alls finns antas 5°C.
+func (a *API) estimatePower(start time.Time, quarters int) ([]models.PowerEstimate, models.ForecastStatus) {
+	forecasts, status := a.forecasts.Forecast()
+	pv := a.pvSystem()
 
 	var estimates []models.PowerEstimate
 
-	for i := 0; i < 192; i++ { // 48 timmar * 4 kvartar
-		timestamp := startOfToday.Add(time.Duration(i) * 15 * time.Minute)
+	for i := 0; i < quarters; i++ {
+		timestamp := start.Add(time.Duration(i) * 15 * time.Minute)
 
 		var power float64
 		if len(forecasts) > 0 {
 			temp := a.smhi.GetTemperatureAt(forecasts, timestamp)
-			power = services.ConsumptionFromTemperature(temp)
+			power = a.consumption.Estimate(timestamp, temp)
 		} else {
 			// Fallback: anta 5°C
-			power = services.ConsumptionFromTemperature(5.0)
+			power = a.consumption.Estimate(timestamp, 5.0)
+		}
+
+		// Solel: molnighet från prognosen, halvklart om den saknas
+		var cloudCover *float64
+		clouds := services.DefaultCloudCover
+		if value, ok := a.smhi.GetCloudCoverAt(forecasts, timestamp); ok {
+			clouds = value
+			cloudCover = &value
 		}
+		var radiation *float64
+		if value, ok := a.smhi.GetGlobalRadiationAt(forecasts, timestamp); ok {
+			radiation = &value
+		}
+		solar := pv.QuarterProductionKW(timestamp, clouds, radiation)
 
 		estimates = append(estimates, models.PowerEstimate{
 			Timestamp:   timestamp,
 			PowerKW:     power,
+			SolarKW:     math.Round(solar*1000) / 1000,
+			NetKW:       math.Round((power-solar)*1000) / 1000,
 			Temperature: a.getTemperatureForTimestamp(forecasts, timestamp),
+			CloudCover:  cloudCover,
 		})
 	}
 
-	c.JSON(http.StatusOK, estimates)
+	return estimates, status
 }
 
 // getTemperatureForTimestamp returnerar temperaturen vid en given tidpunkt, eller 0 om prognos saknas
-func (a *API) getTemperatureForTimestamp(forecasts []services.TemperatureForecast, t time.Time) *float64 {
+func (a *API) getTemperatureForTimestamp(forecasts []models.ForecastPoint, t time.Time) *float64 {
 	if len(forecasts) == 0 {
 		return nil
 	}
@@ -162,72 +386,120 @@ func (a *API) GetBatterySoC(c *gin.Context) {
 	})
 }
 
-// RefreshPrices hämtar nya priser från Entsoe
+// RefreshPrices hämtar nya priser från första prisprovider som svarar
 func (a *API) RefreshPrices(c *gin.Context) {
+	count, source, err := a.UpdatePrices()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Kunde inte hämta priser: %v", err)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("Hämtade %d priser från %s", count, source),
+		"prices":  count,
+		"source":  source,
+	})
+}
+
+// UpdatePrices hämtar priser för idag och imorgon, sparar dem och skickar Pushover-notis.
+// Används av både RefreshPrices och cron-jobbet. Returnerar antal priser och vilken provider som levererade.
+func (a *API) UpdatePrices() (int, string, error) {
 	now := time.Now()
 	startOfToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 	endOfTomorrow := startOfToday.Add(48 * time.Hour)
 
-	// Hämta priser från Entsoe
-	prices, err := a.entsoe.FetchPrices(startOfToday, endOfTomorrow)
+	prices, source, err := a.prices.FetchPrices(startOfToday, endOfTomorrow)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Kunde inte hämta priser: %v", err)})
-		return
+		return 0, "", err
 	}
 
 	// Spara till databas
 	if err := a.db.SavePrices(prices); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return 0, source, err
 	}
 
-	// Beräkna statistik för notifikation
 	if len(prices) > 0 {
-		var sum, min, max int
-		min = prices[0].PriceOre
-		max = prices[0].PriceOre
-
-		for _, p := range prices {
-			sum += p.PriceOre
-			if p.PriceOre < min {
-				min = p.PriceOre
-			}
-			if p.PriceOre > max {
-				max = p.PriceOre
-			}
-		}
+		a.events.Publish(services.EventPrices, gin.H{
+			"count":  len(prices),
+			"source": source,
+			"from":   prices[0].Timestamp,
+			"to":     prices[len(prices)-1].Timestamp.Add(15 * time.Minute),
+		})
+	}
 
-		avg := sum / len(prices)
+	// Lägg ut schemamallar på dagar som fått priser
+	if _, _, err := a.expandTemplateDays(priceDays(prices), true, models.ScheduleRevision{Source: models.ScheduleSourceTemplate}); err != nil {
+		fmt.Printf("Failed to expand schedule templates: %v\n", err)
+	}
 
-		// Skicka Pushover-notis
+	// Skicka Pushover-notis med statistik
+	if len(prices) > 0 {
+		stats := services.CalculatePriceStats(prices)
 		appURL, _ := a.db.GetSetting("app_url")
-		if err := a.pushover.SendPriceUpdateNotification(avg, min, max, appURL); err != nil {
+		if err := a.pushover.SendPriceUpdateNotification(stats.Avg, stats.Min, stats.Max, appURL); err != nil {
 			// Logga fel men fortsätt ändå
 			fmt.Printf("Failed to send Pushover notification: %v\n", err)
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": fmt.Sprintf("Hämtade %d priser", len(prices)),
-		"prices":  len(prices),
-	})
+	return len(prices), source, nil
+}
+
+// GetExchangeRates returnerar de senaste sparade EUR/SEK-kurserna (datum -> SEK per EUR)
+func (a *API) GetExchangeRates(c *gin.Context) {
+	rates, err := a.db.GetExchangeRates("SEK", 30)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"currency": "SEK", "rates": rates})
 }
 
+// secretMask visas istället för hemliga inställningar. Skickas den tillbaka till SaveSettings behålls värdet.
+const secretMask = "********"
+
 // GetSettings returnerar alla inställningar
 func (a *API) GetSettings(c *gin.Context) {
 	settings := map[string]string{
-		"entsoe_token":     "",
-		"pushover_app":     "",
-		"pushover_user":    "",
-		"app_url":          "",
-		"battery_capacity": "42",
-		"ha_url":           "",
-		"ha_token":         "",
+		"entsoe_token":            "",
+		"pushover_app":            "",
+		"pushover_user":           "",
+		"app_url":                 "",
+		"battery_capacity":        "42",
+		"min_soc":                 "15",
+		"battery_efficiency":      "0.9",
+		"charge_curve":            "15:10,80:10,90:5,95:2,100:2",
+		"pv_kwp":                  "0",
+		"pv_tilt":                 "35",
+		"pv_azimuth":              "180",
+		"pv_performance_ratio":    "0.85",
+		"ev_garage_power_kw":      "11",
+		"ev_outdoor_power_kw":     "11",
+		"schedule_retention_days": "30",
+		"ha_url":                  "",
+		"ha_token":                "",
+		"ha_mode_service":         "",
+		"ha_mode_entity":          "",
+		"ha_mode_options":         "",
+		"ha_load_entity":          "",
+		"ha_temperature_entity":   "",
 	}
 
-	// Hämta faktiska värden från databas
+	// Platsen som faktiskt används (kan komma från miljövariabel)
+	lat, lon := a.smhi.Location()
+	settings["smhi_lat"] = strconv.FormatFloat(lat, 'f', 5, 64)
+	settings["smhi_lon"] = strconv.FormatFloat(lon, 'f', 5, 64)
+
+	// Hämta faktiska värden från databas. Hemliga värden visas bara som secretMask.
 	for key := range settings {
+		if key == "smhi_lat" || key == "smhi_lon" {
+			continue
+		}
 		if value, err := a.db.GetSetting(key); err == nil && value != "" {
+			if db.SecretSettings[key] {
+				value = secretMask
+			}
 			settings[key] = value
 		}
 	}
@@ -244,13 +516,88 @@ func (a *API) SaveSettings(c *gin.Context) {
 		return
 	}
 
-	// Spara varje inställning
+	// Ny plats för SMHI-prognosen valideras innan något sparas och gäller direkt
+	latValue, hasLat := settings["smhi_lat"]
+	lonValue, hasLon := settings["smhi_lon"]
+	var newLat, newLon float64
+	if hasLat || hasLon {
+		currentLat, currentLon := a.smhi.Location()
+		if !hasLat {
+			latValue = strconv.FormatFloat(currentLat, 'f', -1, 64)
+		}
+		if !hasLon {
+			lonValue = strconv.FormatFloat(currentLon, 'f', -1, 64)
+		}
+		var err error
+		if newLat, newLon, err = services.ParseSMHILocation(latValue, lonValue); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		settings["smhi_lat"] = latValue
+		settings["smhi_lon"] = lonValue
+	}
+
+	// Spara varje inställning. secretMask betyder att det hemliga värdet ska behållas.
 	for key, value := range settings {
+		if db.SecretSettings[key] && value == secretMask {
+			continue
+		}
 		if err := a.db.SaveSetting(key, value); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 	}
 
+	if hasLat || hasLon {
+		if err := a.smhi.SetLocation(newLat, newLon); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		go func() {
+			if err := a.forecasts.Refresh(); err != nil {
+				fmt.Printf("Failed to fetch SMHI forecast for new location: %v\n", err)
+			}
+		}()
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Inställningar sparade"})
 }
+
+// floatSetting läser en numerisk inställning, med fallback om den saknas eller är ogiltig
+func (a *API) floatSetting(key string, fallback float64) float64 {
+	value, err := a.db.GetSetting(key)
+	if err != nil || value == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 {
+		return fallback
+	}
+	return f
+}
+
+// intSetting läser en heltalsinställning, med fallback om den saknas eller är ogiltig
+func (a *API) intSetting(key string, fallback int) int {
+	value, err := a.db.GetSetting(key)
+	if err != nil || value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback
+	}
+	return n
+}
+
+// boolSetting läser en ja/nej-inställning ("true"/"false"), med fallback om den saknas
+func (a *API) boolSetting(key string, fallback bool) bool {
+	value, err := a.db.GetSetting(key)
+	if err != nil || value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fallback
+	}
+	return b
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/db"
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+func TestKeepScheduleBefore(t *testing.T) {
+	current := []models.ScheduleChange{
+		{Timestamp: at(1, 0), Mode: 2},
+		{Timestamp: at(3, 0), Mode: 1},
+		{Timestamp: at(8, 0), Mode: 3},
+	}
+	// Som ett filtrerat GET-svar från 04:00: brytpunkten som gäller vid from och en ändrad plan
+	changes := []models.ScheduleChange{
+		{Timestamp: at(3, 0), Mode: 1},
+		{Timestamp: at(9, 0), Mode: 2},
+	}
+
+	want := []models.ScheduleChange{
+		{Timestamp: at(1, 0), Mode: 2},
+		{Timestamp: at(3, 0), Mode: 1},
+		{Timestamp: at(9, 0), Mode: 2},
+	}
+	if got := keepScheduleBefore(current, changes, at(4, 0)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %v\nwant %v", got, want)
+	}
+}
+
+// newSettingsTestAPI skapar ett API med krypterade inställningar i en databas i minnet
+func newSettingsTestAPI(t *testing.T) (*API, *gin.Engine) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	database, err := db.NewDatabase(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.SetSecretKey("nyckel"); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &API{db: database, smhi: services.NewSMHIService(services.DefaultSMHILat, services.DefaultSMHILon)}
+	router := gin.New()
+	router.GET("/api/settings", a.GetSettings)
+	router.POST("/api/settings", a.SaveSettings)
+	return a, router
+}
+
+func TestGetSettingsMasksSecrets(t *testing.T) {
+	a, router := newSettingsTestAPI(t)
+	if err := a.db.SaveSetting("ha_token", "abc123"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/settings", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	var settings map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings["ha_token"] != secretMask {
+		t.Errorf("ha_token = %q, want %q", settings["ha_token"], secretMask)
+	}
+	if strings.Contains(w.Body.String(), "abc123") {
+		t.Error("response contains the secret")
+	}
+}
+
+func TestSaveSettingsKeepsMaskedSecret(t *testing.T) {
+	a, router := newSettingsTestAPI(t)
+	if err := a.db.SaveSetting("ha_token", "abc123"); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"ha_token":"` + secretMask + `","entsoe_token":"ny-nyckel"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/settings", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+
+	for key, want := range map[string]string{"ha_token": "abc123", "entsoe_token": "ny-nyckel"} {
+		if value, err := a.db.GetSetting(key); err != nil || value != want {
+			t.Errorf("%s = %q, %v, want %q", key, value, err, want)
+		}
+	}
+}
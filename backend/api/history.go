@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/models"
+)
+
+// GetHistory returnerar sparade kvartsvärden och lägesbyten (?from=&to=, RFC3339 eller YYYY-MM-DD).
+// Default är senaste dygnet.
+func (a *API) GetHistory(c *gin.Context) {
+	now := time.Now()
+	from := now.Add(-24 * time.Hour)
+	to := now
+
+	var err error
+	if value := c.Query("from"); value != "" {
+		if from, err = parseTimeParam(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltigt from, använd RFC3339 eller YYYY-MM-DD"})
+			return
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if to, err = parseTimeParam(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltigt to, använd RFC3339 eller YYYY-MM-DD"})
+			return
+		}
+	}
+	if !to.After(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to måste vara efter from"})
+		return
+	}
+
+	history, err := a.db.GetHistory(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if history == nil {
+		history = []models.HistoryEntry{}
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// parseTimeParam tolkar en tid i query-parametrar, RFC3339 eller datum i lokal tid
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/services"
+)
+
+// GetMetrics returnerar mätvärden i Prometheus textformat. Läge, priser, SoC, prognos och
+// väderprognosens ålder läses vid varje hämtning, räknare och svarstider kommer från tjänsterna.
+func (a *API) GetMetrics(c *gin.Context) {
+	now := time.Now()
+	current := services.NewMetricsRegistry()
+
+	mode := a.scheduler.GetCurrentMode(now)
+	current.SetGauge("battery_scheduler_mode", "Active battery mode (1-6).", float64(mode.Mode))
+	override := 0.0
+	if mode.Override != nil {
+		override = 1
+	}
+	current.SetGauge("battery_scheduler_mode_override", "1 if a manual mode override is active.", override)
+	if !mode.NextChange.IsZero() {
+		current.SetGauge("battery_scheduler_next_mode_change_timestamp_seconds", "Unix time of the next scheduled mode change.", float64(mode.NextChange.Unix()))
+	}
+
+	currentQuarter := now.Truncate(15 * time.Minute)
+	if prices, err := a.pricesWithTariff(currentQuarter, currentQuarter.Add(30*time.Minute)); err == nil {
+		for _, p := range prices {
+			quarter := "current"
+			if p.Timestamp.After(currentQuarter) {
+				quarter = "next"
+			}
+			current.SetGauge("battery_scheduler_price_ore_per_kwh", "Electricity price for the current and next quarter (öre/kWh incl. VAT).",
+				float64(p.PriceOre), "quarter", quarter, "kind", "spot")
+			if p.TotalOre != nil {
+				current.SetGauge("battery_scheduler_price_ore_per_kwh", "Electricity price for the current and next quarter (öre/kWh incl. VAT).",
+					float64(*p.TotalOre), "quarter", quarter, "kind", "total")
+			}
+		}
+	}
+
+	if a.homeAssistant.Configured() {
+		if soc, _, err := a.homeAssistant.GetSoC(); err == nil {
+			current.SetGauge("battery_scheduler_battery_soc_percent", "Battery state of charge from Home Assistant.", soc)
+		}
+	}
+
+	estimates, status := a.estimatePower(currentQuarter, 1)
+	if len(estimates) > 0 {
+		e := estimates[0]
+		for _, kind := range []struct {
+			name  string
+			value float64
+		}{{"load", e.PowerKW}, {"solar", e.SolarKW}, {"net", e.NetKW}} {
+			current.SetGauge("battery_scheduler_estimated_power_kw", "Estimated power for the current quarter.", kind.value, "kind", kind.name)
+		}
+	}
+	if !status.ApprovedTime.IsZero() {
+		current.SetGauge("battery_scheduler_forecast_approved_timestamp_seconds", "Unix time when the SMHI forecast in use was issued.", float64(status.ApprovedTime.Unix()))
+	}
+	stale := 0.0
+	if status.Stale {
+		stale = 1
+	}
+	current.SetGauge("battery_scheduler_forecast_stale", "1 if the SMHI forecast is stale or missing.", stale)
+
+	var body bytes.Buffer
+	if err := current.WriteText(&body); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := services.Metrics.WriteText(&body); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", body.Bytes())
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// OptimizeRequest är parametrar till POST /api/schedule/optimize (alla fält valfria)
+type OptimizeRequest struct {
+	DryRun           bool     `json:"dry_run"`
+	StartSoC         *float64 `json:"start_soc,omitempty"`
+	MinSoC           *float64 `json:"min_soc,omitempty"`
+	Efficiency       *float64 `json:"efficiency,omitempty"`
+	TerminalValueOre float64  `json:"terminal_value_ore"`
+}
+
+// validate kontrollerar att angivna parametrar ger en meningsfull batterimodell
+func (r OptimizeRequest) validate() error {
+	if r.StartSoC != nil && (*r.StartSoC < 0 || *r.StartSoC > 100) {
+		return fmt.Errorf("Ogiltig start_soc %g, ska vara 0-100", *r.StartSoC)
+	}
+	if r.MinSoC != nil && (*r.MinSoC < 0 || *r.MinSoC > 100) {
+		return fmt.Errorf("Ogiltig min_soc %g, ska vara 0-100", *r.MinSoC)
+	}
+	if r.Efficiency != nil && (*r.Efficiency <= 0 || *r.Efficiency > 1) {
+		return fmt.Errorf("Ogiltig efficiency %g, ska vara större än 0 och högst 1", *r.Efficiency)
+	}
+	if r.TerminalValueOre < 0 {
+		return fmt.Errorf("Ogiltigt terminal_value_ore %g, får inte vara negativt", r.TerminalValueOre)
+	}
+	return nil
+}
+
+// OptimizeResponse är svaret från optimeraren
+type OptimizeResponse struct {
+	*services.OptimizerResult
+	DryRun   bool                    `json:"dry_run"`
+	StartSoC float64                 `json:"start_soc"`
+	Merged   []models.ScheduleChange `json:"merged_schedule"`
+}
+
+// OptimizeSchedule räknar fram ett kostnadsoptimalt schema från aktuell kvart och framåt.
+// Med dry_run returneras förslaget utan att det sparas.
+func (a *API) OptimizeSchedule(c *gin.Context) {
+	var req OptimizeRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+			return
+		}
+	}
+	if c.Query("dry_run") == "true" {
+		req.DryRun = true
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	startOfToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	endOfTomorrow := startOfToday.Add(48 * time.Hour)
+	currentQuarter := now.Truncate(15 * time.Minute)
+
+	// Optimera mot det vi faktiskt betalar (spotpris + tariff)
+	prices, err := a.pricesWithTariff(currentQuarter, endOfTomorrow)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(prices) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Inga priser finns för resten av perioden"})
+		return
+	}
+
+	startSoC, err := a.resolveStartSoC(req.StartSoC)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	battery := a.batterySimulator()
+	if req.MinSoC != nil {
+		battery.MinSoC = *req.MinSoC
+	}
+	if req.Efficiency != nil {
+		battery.Efficiency = *req.Efficiency
+	}
+
+	estimates, _ := a.estimatePower(prices[0].Timestamp, len(prices))
+	params := services.OptimizerParams{
+		Prices:           prices,
+		Estimates:        estimates,
+		FixedModes:       make(map[int64]int),
+		StartSoC:         startSoC,
+		Battery:          battery,
+		TerminalValueOre: req.TerminalValueOre,
+	}
+
+	// Lägen som optimeraren inte äger (effektbegränsning och laddboxar) behålls
+	for _, p := range prices {
+		if mode := a.scheduler.GetModeForTime(p.Timestamp); mode >= 4 {
+			params.FixedModes[p.Timestamp.Unix()] = mode
+		}
+	}
+
+	result, err := services.OptimizeSchedule(params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := a.db.GetSchedule()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Bara kvartar med pris ersätts, så morgondagens plan finns kvar innan priserna kommit
+	times := make([]time.Time, len(prices))
+	for i, p := range prices {
+		times[i] = p.Timestamp
+	}
+	merged := mergeQuarterModes(existing, times, result.Modes)
+
+	if !req.DryRun {
+		if _, err := a.applySchedule(merged, a.scheduleRevision(c, models.ScheduleSourceOptimizer)); err != nil {
+			writeScheduleError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, OptimizeResponse{
+		OptimizerResult: result,
+		DryRun:          req.DryRun,
+		StartSoC:        startSoC,
+		Merged:          merged,
+	})
+}
+
+// resolveStartSoC använder angiven SoC eller läser aktuell från Home Assistant
+func (a *API) resolveStartSoC(override *float64) (float64, error) {
+	if override != nil {
+		return *override, nil
+	}
+	soc, _, err := a.homeAssistant.GetSoC()
+	if err != nil {
+		return 0, fmt.Errorf("Kunde inte läsa batterinivå (ange start_soc): %v", err)
+	}
+	return soc, nil
+}
+
+// mergeQuarterModes ersätter schemat under kvartarna i times (i följd) med modes och återställer
+// läget som gällde efter sista kvarten, så att resten av schemat inte påverkas
+func mergeQuarterModes(existing []models.ScheduleChange, times []time.Time, modes []int) []models.ScheduleChange {
+	end := times[len(times)-1].Add(15 * time.Minute)
+	changes := services.ModesToSchedule(times, modes)
+	restore := services.ModeAt(existing, end) != modes[len(modes)-1]
+	for _, change := range existing {
+		if change.Timestamp.Equal(end) {
+			restore = false // Befintlig brytpunkt behålls av mergeScheduleRange
+		}
+	}
+	if restore {
+		changes = append(changes, models.ScheduleChange{Timestamp: end, Mode: services.ModeAt(existing, end)})
+	}
+	return mergeScheduleRange(existing, changes, times[0], end)
+}
+
+// mergeScheduleRange ersätter breakpoints i [from, to) med nya (t.ex. optimerarens förslag)
+// och behåller allt före och efter intervallet
+func mergeScheduleRange(existing, optimized []models.ScheduleChange, from, to time.Time) []models.ScheduleChange {
+	var merged []models.ScheduleChange
+	for _, change := range existing {
+		if change.Timestamp.Before(from) {
+			merged = append(merged, change)
+		}
+	}
+	merged = append(merged, optimized...)
+	for _, change := range existing {
+		if !change.Timestamp.Before(to) {
+			merged = append(merged, change)
+		}
+	}
+	return merged
+}
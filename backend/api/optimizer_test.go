@@ -0,0 +1,127 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+var mergeDay = time.Date(2025, 10, 6, 0, 0, 0, 0, time.UTC)
+
+func at(hour, minute int) time.Time {
+	return mergeDay.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+}
+
+func quarterTimes(from time.Time, n int) []time.Time {
+	times := make([]time.Time, n)
+	for i := range times {
+		times[i] = from.Add(time.Duration(i) * 15 * time.Minute)
+	}
+	return times
+}
+
+func TestMergeQuarterModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []models.ScheduleChange
+		times    []time.Time
+		modes    []int
+		want     []models.ScheduleChange
+	}{
+		{
+			name:     "restores the earlier mode after the horizon",
+			existing: []models.ScheduleChange{{Timestamp: at(0, 0), Mode: 3}},
+			times:    quarterTimes(at(10, 0), 4),
+			modes:    []int{2, 2, 1, 2},
+			want: []models.ScheduleChange{
+				{Timestamp: at(0, 0), Mode: 3},
+				{Timestamp: at(10, 0), Mode: 2},
+				{Timestamp: at(10, 30), Mode: 1},
+				{Timestamp: at(10, 45), Mode: 2},
+				{Timestamp: at(11, 0), Mode: 3},
+			},
+		},
+		{
+			name: "keeps the plan after the last priced quarter",
+			existing: []models.ScheduleChange{
+				{Timestamp: at(10, 15), Mode: 3},
+				{Timestamp: at(22, 0), Mode: 2},
+				{Timestamp: at(30, 0), Mode: 3}, // I morgon, priser saknas än
+			},
+			times: quarterTimes(at(10, 0), 4*14), // 10:00-24:00
+			modes: repeatMode(1, 4*14),
+			want: []models.ScheduleChange{
+				{Timestamp: at(10, 0), Mode: 1},
+				{Timestamp: at(24, 0), Mode: 2},
+				{Timestamp: at(30, 0), Mode: 3},
+			},
+		},
+		{
+			name: "keeps an existing breakpoint at the end",
+			existing: []models.ScheduleChange{
+				{Timestamp: at(10, 0), Mode: 3},
+				{Timestamp: at(11, 0), Mode: 1},
+			},
+			times: quarterTimes(at(10, 0), 4),
+			modes: []int{2, 2, 2, 2},
+			want: []models.ScheduleChange{
+				{Timestamp: at(10, 0), Mode: 2},
+				{Timestamp: at(11, 0), Mode: 1},
+			},
+		},
+		{
+			name:     "no restore when the last mode already continues",
+			existing: []models.ScheduleChange{{Timestamp: at(0, 0), Mode: 2}},
+			times:    quarterTimes(at(10, 0), 2),
+			modes:    []int{3, 2},
+			want: []models.ScheduleChange{
+				{Timestamp: at(0, 0), Mode: 2},
+				{Timestamp: at(10, 0), Mode: 3},
+				{Timestamp: at(10, 15), Mode: 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeQuarterModes(tt.existing, tt.times, tt.modes)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func repeatMode(mode, n int) []int {
+	modes := make([]int, n)
+	for i := range modes {
+		modes[i] = mode
+	}
+	return modes
+}
+
+func TestOptimizeRequestValidate(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name    string
+		req     OptimizeRequest
+		wantErr bool
+	}{
+		{"defaults", OptimizeRequest{}, false},
+		{"all set", OptimizeRequest{StartSoC: value(50), MinSoC: value(15), Efficiency: value(1), TerminalValueOre: 80}, false},
+		{"start soc above 100", OptimizeRequest{StartSoC: value(101)}, true},
+		{"negative min soc", OptimizeRequest{MinSoC: value(-1)}, true},
+		{"min soc above 100", OptimizeRequest{MinSoC: value(150)}, true},
+		{"zero efficiency", OptimizeRequest{Efficiency: value(0)}, true},
+		{"efficiency above 1", OptimizeRequest{Efficiency: value(1.2)}, true},
+		{"negative terminal value", OptimizeRequest{TerminalValueOre: -5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// OverrideRequest är parametrar till POST /api/override. Ange minutes eller expires.
+type OverrideRequest struct {
+	Mode    int        `json:"mode"`
+	Minutes int        `json:"minutes"`           // Varaktighet från start
+	Start   *time.Time `json:"start,omitempty"`   // Default nu
+	Expires *time.Time `json:"expires,omitempty"` // Sluttid istället för minutes
+	Reason  string     `json:"reason"`
+}
+
+// GetOverride returnerar aktivt eller kommande manuellt läge
+func (a *API) GetOverride(c *gin.Context) {
+	override := a.scheduler.Override(time.Now())
+	if override == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inget manuellt läge är satt"})
+		return
+	}
+
+	c.JSON(http.StatusOK, override)
+}
+
+// SetOverride tvingar ett läge under en tid utan att ändra schemat, t.ex. läge 2 de närmaste två
+// timmarna. Ersätter tidigare manuellt läge. När det går ut gäller schemat igen.
+func (a *API) SetOverride(c *gin.Context) {
+	var req OverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+
+	now := time.Now()
+	override := models.ModeOverride{
+		Mode:      req.Mode,
+		Start:     now,
+		Reason:    req.Reason,
+		Author:    requestAuthor(c),
+		CreatedAt: now,
+	}
+	if req.Start != nil {
+		override.Start = *req.Start
+	}
+	switch {
+	case req.Expires != nil && req.Minutes == 0:
+		override.Expires = *req.Expires
+	case req.Expires == nil && req.Minutes > 0:
+		override.Expires = override.Start.Add(time.Duration(req.Minutes) * time.Minute)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ange antingen minutes eller expires"})
+		return
+	}
+	if err := services.ValidateOverride(override, now); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.db.SaveModeOverride(&override); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	a.scheduler.SetOverride(override)
+	if a.executor != nil {
+		a.executor.Notify()
+	}
+	a.events.Publish(services.EventOverride, override)
+
+	c.JSON(http.StatusOK, gin.H{
+		"override":     override,
+		"current_mode": a.scheduler.GetCurrentMode(now),
+	})
+}
+
+// ClearOverride tar bort manuellt läge så att schemat gäller direkt
+func (a *API) ClearOverride(c *gin.Context) {
+	if err := a.db.SaveModeOverride(nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	now := time.Now()
+	if !a.scheduler.ClearOverride(now) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Inget manuellt läge är satt"})
+		return
+	}
+	if a.executor != nil {
+		a.executor.Notify()
+	}
+	a.events.Publish(services.EventOverride, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Manuellt läge borttaget",
+		"current_mode": a.scheduler.GetCurrentMode(now),
+	})
+}
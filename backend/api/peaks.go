@@ -0,0 +1,165 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// PeakPlanRequest är parametrar till POST /api/peaks/plan
+type PeakPlanRequest struct {
+	DryRun   bool     `json:"dry_run"`
+	StartSoC *float64 `json:"start_soc,omitempty"`
+}
+
+// GetPeaks returnerar månadens effekttoppar (default innevarande månad, ?month=YYYY-MM)
+func (a *API) GetPeaks(c *gin.Context) {
+	month := time.Now()
+	if value := c.Query("month"); value != "" {
+		parsed, err := time.ParseInLocation("2006-01", value, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltig månad, använd YYYY-MM"})
+			return
+		}
+		month = parsed
+	}
+
+	rules := a.peakRules()
+	summary, err := a.monthPeaks(month, rules)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"summary": summary,
+		"rules":   rules,
+	}
+	if current, ok := a.peaks.Current(); ok {
+		current.WeightedKW = current.AvgKW * rules.Weight(current.Hour)
+		response["current_hour"] = current
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// PlanPeaks lägger in läge 4 (effektbegränsning) där prognosticerad nätimport för
+// sparat schema skulle sätta en ny månadstopp. Med dry_run sparas inget.
+func (a *API) PlanPeaks(c *gin.Context) {
+	var req PeakPlanRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+			return
+		}
+	}
+	if c.Query("dry_run") == "true" {
+		req.DryRun = true
+	}
+
+	startSoC, err := a.resolveStartSoC(req.StartSoC)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	startOfToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	endOfTomorrow := startOfToday.Add(48 * time.Hour)
+	currentQuarter := now.Truncate(15 * time.Minute)
+	quarters := int(endOfTomorrow.Sub(currentQuarter) / (15 * time.Minute))
+
+	schedule, err := a.db.GetSchedule()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	rules := a.peakRules()
+	summary, err := a.monthPeaks(now, rules)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	estimates, _ := a.estimatePower(currentQuarter, quarters)
+	points := a.batterySimulator().Simulate(schedule, estimates, startSoC)
+	modes, flagged := services.PlanPeakLimiting(points, summary, rules)
+
+	// Bara simulerade kvartar ersätts, och läget efter sista kvarten återställs
+	merged := schedule
+	if len(points) > 0 {
+		times := make([]time.Time, len(points))
+		for i, p := range points {
+			times[i] = p.Timestamp
+		}
+		merged = mergeQuarterModes(schedule, times, modes)
+	}
+
+	if !req.DryRun && len(flagged) > 0 {
+		if _, err := a.applySchedule(merged, a.scheduleRevision(c, models.ScheduleSourcePeaks)); err != nil {
+			writeScheduleError(c, err)
+			return
+		}
+	}
+
+	if flagged == nil {
+		flagged = []services.PeakPlanHour{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"dry_run":         req.DryRun,
+		"threshold_kw":    summary.ThresholdKW,
+		"flagged_hours":   flagged,
+		"merged_schedule": merged,
+	})
+}
+
+// monthPeaks hämtar månadens timvärden (inkl pågående timme) och räknar fram topparna
+func (a *API) monthPeaks(month time.Time, rules services.PeakRules) (models.PeakSummary, error) {
+	start := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(0, 1, 0)
+
+	hourly, err := a.db.GetHourlyPower(start, end)
+	if err != nil {
+		return models.PeakSummary{}, err
+	}
+	if current, ok := a.peaks.Current(); ok && !current.Hour.Before(start) && current.Hour.Before(end) {
+		hourly = append(hourly, current)
+	}
+
+	return services.MonthPeaks(start, hourly, rules), nil
+}
+
+// peakRules läser nätägarens effekttariffregler från settings
+func (a *API) peakRules() services.PeakRules {
+	rules := services.PeakRules{
+		TopN:        a.intSetting("peak_top_n", services.DefaultPeakRules.TopN),
+		OnePerDay:   a.boolSetting("peak_one_per_day", services.DefaultPeakRules.OnePerDay),
+		NightWeight: a.floatSetting("peak_night_weight", services.DefaultPeakRules.NightWeight),
+		NightStart:  a.intSetting("peak_night_start", services.DefaultPeakRules.NightStart),
+		NightEnd:    a.intSetting("peak_night_end", services.DefaultPeakRules.NightEnd),
+		FloorKW:     a.floatSetting("peak_floor_kw", 0),
+	}
+
+	if value, _ := a.db.GetSetting("peak_months"); value != "" {
+		for _, part := range strings.Split(value, ",") {
+			var m int
+			if _, err := fmt.Sscanf(strings.TrimSpace(part), "%d", &m); err == nil {
+				rules.Months = append(rules.Months, m)
+			}
+		}
+	}
+
+	if err := services.ValidatePeakRules(rules); err != nil {
+		fmt.Printf("Invalid peak tariff settings, using defaults: %v\n", err)
+		return services.DefaultPeakRules
+	}
+
+	return rules
+}
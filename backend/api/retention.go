@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// defaultScheduleRetentionDays är hur många dagar bakåt brytpunkter ligger kvar i schemat
+const defaultScheduleRetentionDays = 30
+
+// PurgeSchedule rensar gamla brytpunkter direkt istället för att vänta på nattens jobb
+func (a *API) PurgeSchedule(c *gin.Context) {
+	removed, revisionID, err := a.PurgeOldBreakpoints()
+	if err != nil {
+		writeScheduleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"removed":  removed,
+		"revision": revisionID, // 0 om inget rensades
+	})
+}
+
+// PurgeOldBreakpoints tar bort brytpunkter äldre än schedule_retention_days (default 30) ur schemat.
+// De finns kvar i tidigare schemaversioner, och sista brytpunkten före gränsen behålls så att
+// aktuellt läge inte ändras. Returnerar antal borttagna brytpunkter och den nya versionens ID.
+func (a *API) PurgeOldBreakpoints() (int, int, error) {
+	days := a.intSetting("schedule_retention_days", defaultScheduleRetentionDays)
+	if days < 1 {
+		days = 1
+	}
+	cutoff := time.Now().AddDate(0, 0, -days)
+
+	removed := 0
+	revisionID, err := a.modifySchedule(func(current []models.ScheduleChange) ([]models.ScheduleChange, error) {
+		kept, old := services.PruneSchedule(current, cutoff)
+		if len(old) == 0 {
+			return nil, errScheduleUnchanged
+		}
+		removed = len(old)
+		return kept, nil
+	}, models.ScheduleRevision{Source: models.ScheduleSourceRetention}, "")
+	if errors.Is(err, errScheduleUnchanged) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return removed, revisionID, nil
+}
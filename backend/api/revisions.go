@@ -0,0 +1,143 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// GetScheduleRevisions listar sparade schemaversioner, nyast först (?limit=, default 50)
+func (a *API) GetScheduleRevisions(c *gin.Context) {
+	limit := 50
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit måste vara mellan 1 och 1000"})
+			return
+		}
+		limit = n
+	}
+
+	revisions, err := a.db.GetScheduleRevisions(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if revisions == nil {
+		revisions = []models.ScheduleRevision{}
+	}
+
+	c.JSON(http.StatusOK, revisions)
+}
+
+// GetScheduleRevision returnerar en schemaversion med alla brytpunkter
+func (a *API) GetScheduleRevision(c *gin.Context) {
+	revision, ok := a.lookupRevision(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, revision)
+}
+
+// DiffScheduleRevisions jämför versionen :id med ?to= (default senaste versionen)
+func (a *API) DiffScheduleRevisions(c *gin.Context) {
+	from, ok := a.lookupRevision(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	toID := c.Query("to")
+	if toID == "" {
+		latest, err := a.db.GetScheduleRevisions(1)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(latest) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Inga schemaversioner finns"})
+			return
+		}
+		toID = strconv.Itoa(latest[0].ID)
+	}
+	to, ok := a.lookupRevision(c, toID)
+	if !ok {
+		return
+	}
+
+	diff := models.ScheduleDiff{From: from.ID, To: to.ID}
+	diff.Added, diff.Removed, diff.Changed = services.DiffSchedules(from.Schedule, to.Schedule)
+
+	c.JSON(http.StatusOK, diff)
+}
+
+// RestoreScheduleRevision gör en tidigare version till aktuellt schema från aktuell kvart och framåt.
+// Återställningen sparas som en ny version, så historiken skrivs aldrig om.
+func (a *API) RestoreScheduleRevision(c *gin.Context) {
+	revision, ok := a.lookupRevision(c, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	restore := a.scheduleRevision(c, models.ScheduleSourceRestore)
+	restore.RestoredFrom = &revision.ID
+
+	// Det som redan hänt ändras inte: brytpunkter före aktuell kvart behålls
+	currentQuarter := time.Now().Truncate(15 * time.Minute)
+	restored := []models.ScheduleChange{{Timestamp: currentQuarter, Mode: services.ModeAt(revision.Schedule, currentQuarter)}}
+	for _, change := range revision.Schedule {
+		if change.Timestamp.After(currentQuarter) {
+			restored = append(restored, change)
+		}
+	}
+	revisionID, err := a.modifySchedule(func(current []models.ScheduleChange) ([]models.ScheduleChange, error) {
+		var schedule []models.ScheduleChange
+		for _, change := range current {
+			if change.Timestamp.Before(currentQuarter) {
+				schedule = append(schedule, change)
+			}
+		}
+		if services.ModeAt(schedule, currentQuarter) == restored[0].Mode {
+			restored = restored[1:] // Onödig brytpunkt
+		}
+		return append(schedule, restored...), nil
+	}, restore, "")
+	if err != nil {
+		writeScheduleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Schema återställt",
+		"revision":      revisionID,
+		"restored_from": revision.ID,
+	})
+}
+
+// lookupRevision hämtar en version från ett id i URL:en och svarar med fel om den inte finns
+func (a *API) lookupRevision(c *gin.Context, value string) (*models.ScheduleRevision, bool) {
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltigt id"})
+		return nil, false
+	}
+
+	revision, err := a.db.GetScheduleRevision(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schemaversionen finns inte"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return revision, true
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// SchedulePatchRequest är en lista intervalloperationer till PATCH /api/schedule
+type SchedulePatchRequest struct {
+	Operations []services.ScheduleOperation `json:"operations"`
+}
+
+// PatchSchedule ändrar delar av schemat utan att skicka hela listan. Operationerna (set och clear)
+// läggs in i ordning i aktivt schema med samma regler som webbgränssnittet, och allt sparas som
+// en version. Med If-Match görs ändringen bara om schemat inte ändrats sedan det hämtades.
+func (a *API) PatchSchedule(c *gin.Context) {
+	var req SchedulePatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if len(req.Operations) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Inga operationer"})
+		return
+	}
+
+	source := models.ScheduleSourceAPI
+	if c.GetHeader("X-Schedule-Source") == models.ScheduleSourceUI {
+		source = models.ScheduleSourceUI
+	}
+
+	var result []models.ScheduleChange
+	revisionID, err := a.modifySchedule(func(current []models.ScheduleChange) ([]models.ScheduleChange, error) {
+		schedule := current
+		for i, op := range req.Operations {
+			var err error
+			schedule, err = services.ApplyScheduleOperation(schedule, op)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i+1, err)
+			}
+		}
+		result = schedule
+		return schedule, nil
+	}, a.scheduleRevision(c, source), c.GetHeader("If-Match"))
+	if err != nil {
+		writeScheduleError(c, err)
+		return
+	}
+
+	c.Header("ETag", scheduleETag(revisionID))
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Schema sparat",
+		"revision": revisionID,
+		"schedule": result,
+		"warnings": a.scheduleWarnings(result),
+	})
+}
+
+// scheduleETag är schemats ETag för en version
+func scheduleETag(revisionID int) string {
+	return fmt.Sprintf(`"rev-%d"`, revisionID)
+}
+
+// etagMatches avgör om ett If-Match-värde (lista eller *) matchar etag
+func etagMatches(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
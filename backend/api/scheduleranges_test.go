@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/db"
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// newScheduleTestAPI skapar ett API med bara databas, scheduler och händelser
+func newScheduleTestAPI(t *testing.T) *API {
+	t.Helper()
+	database, err := db.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	scheduler := services.NewSchedulerService(nil)
+	return &API{db: database, scheduler: scheduler, events: services.NewEventHub(scheduler, nil)}
+}
+
+func setQuarters(from time.Time, mode int) func([]models.ScheduleChange) ([]models.ScheduleChange, error) {
+	return func(current []models.ScheduleChange) ([]models.ScheduleChange, error) {
+		return services.ApplyScheduleOperation(current, services.ScheduleOperation{
+			Op: services.ScheduleOpSet, From: from, To: from.Add(time.Hour), Mode: mode,
+		})
+	}
+}
+
+func TestModifyScheduleIfMatch(t *testing.T) {
+	a := newScheduleTestAPI(t)
+	from := time.Now().Truncate(15 * time.Minute).Add(2 * time.Hour)
+	revision := models.ScheduleRevision{Source: models.ScheduleSourceAPI}
+
+	first, err := a.modifySchedule(setQuarters(from, 2), revision, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := a.modifySchedule(setQuarters(from, 3), revision, scheduleETag(first))
+	if err != nil {
+		t.Fatalf("current If-Match rejected: %v", err)
+	}
+
+	_, err = a.modifySchedule(setQuarters(from, 2), revision, scheduleETag(first))
+	var preconditionErr *schedulePreconditionError
+	if !errors.As(err, &preconditionErr) {
+		t.Fatalf("stale If-Match error = %v, want *schedulePreconditionError", err)
+	}
+	if preconditionErr.etag != scheduleETag(second) {
+		t.Errorf("etag = %s, want %s", preconditionErr.etag, scheduleETag(second))
+	}
+	if mode := a.scheduler.GetModeForTime(from); mode != 3 {
+		t.Errorf("mode after rejected change = %d, want 3", mode)
+	}
+
+	if _, err := a.modifySchedule(setQuarters(from, 2), revision, "*"); err != nil {
+		t.Errorf("If-Match * rejected: %v", err)
+	}
+}
+
+func TestPatchScheduleStaleIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := newScheduleTestAPI(t)
+	from := time.Now().Truncate(15 * time.Minute).Add(2 * time.Hour)
+
+	current, err := a.modifySchedule(setQuarters(from, 2), models.ScheduleRevision{Source: models.ScheduleSourceAPI}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.PATCH("/api/schedule", a.PatchSchedule)
+	body := `{"operations":[{"op":"clear","from":"` + from.Format(time.RFC3339) + `","to":"` + from.Add(time.Hour).Format(time.RFC3339) + `"}]}`
+	req := httptest.NewRequest(http.MethodPatch, "/api/schedule", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", scheduleETag(current-1))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("status = %d, want 412: %s", w.Code, w.Body.String())
+	}
+	if etag := w.Header().Get("ETag"); etag != scheduleETag(current) {
+		t.Errorf("ETag = %q, want %q", etag, scheduleETag(current))
+	}
+	if mode := a.scheduler.GetModeForTime(from); mode != 2 {
+		t.Errorf("mode after rejected patch = %d, want 2", mode)
+	}
+}
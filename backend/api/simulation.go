@@ -0,0 +1,113 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// SimulationRequest är ett utkast till schema som ska simuleras (POST /api/simulation)
+type SimulationRequest struct {
+	Schedule []models.ScheduleChange `json:"schedule"`
+	StartSoC *float64                `json:"start_soc,omitempty"`
+}
+
+// GetSimulation returnerar simulerad batterinivå per kvart för det sparade schemat,
+// från aktuell kvart till slutet av morgondagen
+func (a *API) GetSimulation(c *gin.Context) {
+	var startSoC *float64
+	if value := c.Query("start_soc"); value != "" {
+		soc, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltig start_soc"})
+			return
+		}
+		startSoC = &soc
+	}
+
+	schedule, err := a.db.GetSchedule()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	a.respondSimulation(c, schedule, startSoC)
+}
+
+// SimulateSchedule simulerar ett osparat schema, så att UI:t kan visa SoC-kurvan medan man redigerar
+func (a *API) SimulateSchedule(c *gin.Context) {
+	var req SimulationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+
+	a.respondSimulation(c, req.Schedule, req.StartSoC)
+}
+
+func (a *API) respondSimulation(c *gin.Context, schedule []models.ScheduleChange, startSoC *float64) {
+	c.JSON(http.StatusOK, a.simulate(schedule, startSoC))
+}
+
+// simulate simulerar schemat från aktuell kvart till slutet av morgondagen
+func (a *API) simulate(schedule []models.ScheduleChange, startSoC *float64) []models.SimulationPoint {
+	soc, err := a.resolveStartSoC(startSoC)
+	if err != nil {
+		fmt.Printf("Failed to fetch SoC for simulation, using fallback: %v\n", err)
+		soc = 50.0 // Samma fallback som /api/battery-soc
+	}
+
+	now := time.Now()
+	startOfToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	endOfTomorrow := startOfToday.Add(48 * time.Hour)
+	currentQuarter := now.Truncate(15 * time.Minute)
+	quarters := int(endOfTomorrow.Sub(currentQuarter) / (15 * time.Minute))
+
+	estimates, _ := a.estimatePower(currentQuarter, quarters)
+	return a.batterySimulator().Simulate(schedule, estimates, soc)
+}
+
+// batterySimulator bygger batterimodellen från settings
+// (battery_capacity, min_soc, battery_efficiency och charge_curve)
+func (a *API) batterySimulator() *services.BatterySimulator {
+	sim := services.NewBatterySimulator(a.floatSetting("battery_capacity", 42), a.floatSetting("min_soc", 15))
+	sim.Efficiency = a.floatSetting("battery_efficiency", 0.9)
+
+	if value, err := a.db.GetSetting("charge_curve"); err == nil && value != "" {
+		curve, err := services.ParseChargeCurve(value)
+		if err != nil {
+			fmt.Printf("Invalid charge_curve setting, using default: %v\n", err)
+		} else {
+			sim.ChargeCurve = curve
+		}
+	}
+
+	return sim
+}
+
+// pvSystem bygger solcellsmodellen från settings (pv_kwp, pv_tilt, pv_azimuth och
+// pv_performance_ratio) och SMHI-punktens koordinater
+func (a *API) pvSystem() services.PVSystem {
+	lat, lon := a.smhi.Location()
+	pv := services.PVSystem{
+		KWp:              a.floatSetting("pv_kwp", 0),
+		TiltDeg:          a.floatSetting("pv_tilt", services.DefaultPVSystem.TiltDeg),
+		AzimuthDeg:       a.floatSetting("pv_azimuth", services.DefaultPVSystem.AzimuthDeg),
+		PerformanceRatio: a.floatSetting("pv_performance_ratio", services.DefaultPVSystem.PerformanceRatio),
+		Lat:              lat,
+		Lon:              lon,
+	}
+
+	if err := services.ValidatePVSystem(pv); err != nil {
+		fmt.Printf("Invalid PV settings, ignoring solar production: %v\n", err)
+		return services.PVSystem{Lat: lat, Lon: lon}
+	}
+
+	return pv
+}
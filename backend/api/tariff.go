@@ -0,0 +1,112 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// GetTariff returnerar alla tariffkomponenter
+func (a *API) GetTariff(c *gin.Context) {
+	components, err := a.db.GetTariffComponents()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if components == nil {
+		components = []models.TariffComponent{}
+	}
+
+	c.JSON(http.StatusOK, components)
+}
+
+// CreateTariffComponent lägger till en tariffkomponent
+func (a *API) CreateTariffComponent(c *gin.Context) {
+	component := models.TariffComponent{Enabled: true}
+	if err := c.ShouldBindJSON(&component); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	component.ID = 0
+
+	a.saveTariffComponent(c, component, http.StatusCreated)
+}
+
+// UpdateTariffComponent ersätter en befintlig tariffkomponent
+func (a *API) UpdateTariffComponent(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltigt id"})
+		return
+	}
+
+	component := models.TariffComponent{Enabled: true}
+	if err := c.ShouldBindJSON(&component); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	component.ID = id
+
+	a.saveTariffComponent(c, component, http.StatusOK)
+}
+
+func (a *API) saveTariffComponent(c *gin.Context, component models.TariffComponent, status int) {
+	if err := services.ValidateTariffComponent(component); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := a.db.SaveTariffComponent(component)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tariffkomponenten finns inte"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	component.ID = id
+
+	c.JSON(status, component)
+}
+
+// DeleteTariffComponent tar bort en tariffkomponent
+func (a *API) DeleteTariffComponent(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltigt id"})
+		return
+	}
+
+	if err := a.db.DeleteTariffComponent(id); errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tariffkomponenten finns inte"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tariffkomponent borttagen"})
+}
+
+// pricesWithTariff hämtar priser för ett intervall med totalkostnad (TotalOre) beräknad
+func (a *API) pricesWithTariff(from, to time.Time) ([]models.Price, error) {
+	prices, err := a.db.GetPrices(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	components, err := a.db.GetTariffComponents()
+	if err != nil {
+		return nil, err
+	}
+
+	return services.ApplyTariff(prices, components), nil
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// errScheduleUnchanged betyder att mallarna inte ändrade något och ingen version sparades
+var errScheduleUnchanged = errors.New("schemat ändrades inte")
+
+// GetScheduleTemplates returnerar alla schemamallar
+func (a *API) GetScheduleTemplates(c *gin.Context) {
+	templates, err := a.db.GetScheduleTemplates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if templates == nil {
+		templates = []models.ScheduleTemplate{}
+	}
+
+	c.JSON(http.StatusOK, templates)
+}
+
+// CreateScheduleTemplate lägger till en schemamall
+func (a *API) CreateScheduleTemplate(c *gin.Context) {
+	template := models.ScheduleTemplate{Enabled: true}
+	if err := c.ShouldBindJSON(&template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	template.ID = 0
+
+	a.saveScheduleTemplate(c, template, http.StatusCreated)
+}
+
+// UpdateScheduleTemplate ersätter en befintlig schemamall. Dagar som redan fått mallarna utlagda ändras inte.
+func (a *API) UpdateScheduleTemplate(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltigt id"})
+		return
+	}
+
+	template := models.ScheduleTemplate{Enabled: true}
+	if err := c.ShouldBindJSON(&template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	template.ID = id
+
+	a.saveScheduleTemplate(c, template, http.StatusOK)
+}
+
+func (a *API) saveScheduleTemplate(c *gin.Context, template models.ScheduleTemplate, status int) {
+	if err := services.ValidateScheduleTemplate(template); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, err := a.db.SaveScheduleTemplate(template)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schemamallen finns inte"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	template.ID = id
+
+	c.JSON(status, template)
+}
+
+// DeleteScheduleTemplate tar bort en schemamall
+func (a *API) DeleteScheduleTemplate(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltigt id"})
+		return
+	}
+
+	if err := a.db.DeleteScheduleTemplate(id); errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schemamallen finns inte"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Schemamall borttagen"})
+}
+
+// ExpandScheduleTemplates lägger ut mallarna på ?date=YYYY-MM-DD (default idag och imorgon), även
+// om dagen redan fått dem. Konkreta lägen i schemat går fortfarande före mallarna.
+func (a *API) ExpandScheduleTemplates(c *gin.Context) {
+	now := time.Now()
+	startOfToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	days := []time.Time{startOfToday, startOfToday.AddDate(0, 0, 1)}
+	if value := c.Query("date"); value != "" {
+		day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Ogiltigt datum (YYYY-MM-DD)"})
+			return
+		}
+		days = []time.Time{day}
+	}
+
+	revisionID, quarters, err := a.expandTemplateDays(days, false, a.scheduleRevision(c, models.ScheduleSourceTemplate))
+	if err != nil {
+		writeScheduleError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"revision": revisionID, // 0 om schemat inte ändrades
+		"quarters": quarters,
+	})
+}
+
+// expandTemplateDays lägger ut aktiva mallar på dagarna (lokal midnatt) från nuvarande kvart och
+// framåt, som en schemaversion. Med skipExpanded hoppas dagar över som redan fått mallarna.
+// Dagarna noteras som utlagda även om inget ändrades. Returnerar versionens ID (0 om schemat inte
+// ändrades) och antal kvartar som fick mallens läge.
+func (a *API) expandTemplateDays(days []time.Time, skipExpanded bool, revision models.ScheduleRevision) (int, int, error) {
+	templates, err := a.db.GetScheduleTemplates()
+	if err != nil {
+		return 0, 0, err
+	}
+	enabled := false
+	for _, t := range templates {
+		enabled = enabled || t.Enabled
+	}
+	if !enabled {
+		return 0, 0, nil
+	}
+
+	keys := make([]string, 0, len(days))
+	for _, day := range days {
+		keys = append(keys, day.Format("2006-01-02"))
+	}
+	if skipExpanded {
+		expanded, err := a.db.GetTemplateDays(keys)
+		if err != nil {
+			return 0, 0, err
+		}
+		var remainingDays []time.Time
+		var remainingKeys []string
+		for i, key := range keys {
+			if !expanded[key] {
+				remainingDays = append(remainingDays, days[i])
+				remainingKeys = append(remainingKeys, key)
+			}
+		}
+		days, keys = remainingDays, remainingKeys
+	}
+	if len(days) == 0 {
+		return 0, 0, nil
+	}
+
+	currentQuarter := time.Now().Truncate(15 * time.Minute)
+	quarters := 0
+	revisionID, err := a.modifySchedule(func(current []models.ScheduleChange) ([]models.ScheduleChange, error) {
+		schedule := current
+		for _, day := range days {
+			var times []time.Time
+			for t := day; t.Before(day.AddDate(0, 0, 1)); t = t.Add(15 * time.Minute) {
+				if !t.Before(currentQuarter) {
+					times = append(times, t)
+				}
+			}
+			if len(times) == 0 {
+				continue
+			}
+			modes, n := services.ExpandTemplates(templates, schedule, times)
+			if n > 0 {
+				schedule = mergeQuarterModes(schedule, times, modes)
+				quarters += n
+			}
+		}
+		if quarters == 0 {
+			return nil, errScheduleUnchanged
+		}
+		return schedule, nil
+	}, revision, "")
+	if errors.Is(err, errScheduleUnchanged) {
+		err = nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := a.db.MarkTemplateDays(keys, revisionID); err != nil {
+		return revisionID, quarters, err
+	}
+	return revisionID, quarters, nil
+}
+
+// priceDays returnerar de dagar (lokal midnatt) som priserna täcker
+func priceDays(prices []models.Price) []time.Time {
+	var days []time.Time
+	seen := make(map[time.Time]bool)
+	for _, p := range prices {
+		t := p.Timestamp.In(time.Local)
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+		if !seen[day] {
+			seen[day] = true
+			days = append(days, day)
+		}
+	}
+	return days
+}
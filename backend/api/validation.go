@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"battery-scheduler/models"
+	"battery-scheduler/services"
+)
+
+// ValidateSchedule kontrollerar ett schema utan att spara det och returnerar alla regelbrott,
+// även laddning och urladdning som simuleringen visar inte gör någon nytta
+func (a *API) ValidateSchedule(c *gin.Context) {
+	var schedule []models.ScheduleChange
+	if err := c.ShouldBindJSON(&schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+
+	violations := services.CheckSchedule(schedule, a.scheduler.Snapshot(), time.Now())
+	if !services.HasScheduleErrors(violations) {
+		violations = append(violations, a.simulationWarnings(schedule)...)
+	}
+	if violations == nil {
+		violations = []models.ScheduleViolation{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"valid":      !services.HasScheduleErrors(violations),
+		"violations": violations,
+	})
+}
+
+// scheduleWarnings returnerar varningar för ett schema som har sparats
+func (a *API) scheduleWarnings(schedule []models.ScheduleChange) []models.ScheduleViolation {
+	warnings := []models.ScheduleViolation{}
+	for _, v := range services.CheckSchedule(schedule, schedule, time.Now()) {
+		if v.Severity == services.SeverityWarning {
+			warnings = append(warnings, v)
+		}
+	}
+	return append(warnings, a.simulationWarnings(schedule)...)
+}
+
+// simulationWarnings simulerar schemat och varnar för laddning mot fullt och urladdning mot tomt batteri
+func (a *API) simulationWarnings(schedule []models.ScheduleChange) []models.ScheduleViolation {
+	return a.batterySimulator().CheckSimulation(a.simulate(schedule, nil))
+}
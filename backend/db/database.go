@@ -1,8 +1,13 @@
 package db
 
 import (
+	"crypto/cipher"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"battery-scheduler/models"
@@ -11,7 +16,8 @@ import (
 )
 
 type Database struct {
-	db *sql.DB
+	db      *sql.DB
+	secrets cipher.AEAD // nil om hemliga inställningar sparas i klartext
 }
 
 // NewDatabase skapar eller öppnar databasen
@@ -53,8 +59,12 @@ func (d *Database) migrate() error {
     );
 
     CREATE TABLE IF NOT EXISTS power_estimates (
-        timestamp DATETIME PRIMARY KEY,
-        power_kw REAL NOT NULL
+        issued_at DATETIME NOT NULL,
+        timestamp DATETIME NOT NULL,
+        power_kw REAL NOT NULL,
+        solar_kw REAL NOT NULL DEFAULT 0,
+        temperature REAL,
+        PRIMARY KEY (issued_at, timestamp)
     );
 
     CREATE TABLE IF NOT EXISTS settings (
@@ -70,14 +80,197 @@ func (d *Database) migrate() error {
         price_ore INTEGER
     );
 
+    CREATE TABLE IF NOT EXISTS exchange_rates (
+        date TEXT NOT NULL,
+        currency TEXT NOT NULL,
+        rate REAL NOT NULL,
+        PRIMARY KEY (date, currency)
+    );
+
+    CREATE TABLE IF NOT EXISTS tariff_components (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL,
+        kind TEXT NOT NULL,
+        type TEXT NOT NULL,
+        ore_per_kwh REAL NOT NULL,
+        start_hour INTEGER,
+        end_hour INTEGER,
+        weekdays TEXT DEFAULT '',
+        months TEXT DEFAULT '',
+        enabled INTEGER NOT NULL DEFAULT 1
+    );
+
+    CREATE TABLE IF NOT EXISTS hourly_power (
+        hour DATETIME PRIMARY KEY,
+        avg_kw REAL NOT NULL,
+        samples INTEGER NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS consumption_models (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        fitted_at DATETIME NOT NULL,
+        model TEXT NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS forecast_fetches (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        fetched_at DATETIME NOT NULL,
+        approved_time DATETIME NOT NULL,
+        lat REAL NOT NULL,
+        lon REAL NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS weather_forecast (
+        fetch_id INTEGER NOT NULL,
+        time DATETIME NOT NULL,
+        temperature REAL NOT NULL,
+        cloud_cover REAL,
+        global_radiation REAL,
+        PRIMARY KEY (fetch_id, time)
+    );
+
+    CREATE TABLE IF NOT EXISTS schedule_revisions (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        created_at DATETIME NOT NULL,
+        source TEXT NOT NULL,
+        author TEXT NOT NULL DEFAULT '',
+        restored_from INTEGER
+    );
+
+    CREATE TABLE IF NOT EXISTS schedule_revision_changes (
+        revision_id INTEGER NOT NULL,
+        timestamp DATETIME NOT NULL,
+        mode INTEGER NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS schedule_templates (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL,
+        weekdays TEXT NOT NULL DEFAULT '',
+        entries TEXT NOT NULL,
+        enabled INTEGER NOT NULL DEFAULT 1
+    );
+
+    CREATE TABLE IF NOT EXISTS schedule_template_days (
+        day TEXT PRIMARY KEY,
+        expanded_at DATETIME NOT NULL,
+        revision_id INTEGER
+    );
+
+    CREATE TABLE IF NOT EXISTS mode_override (
+        id INTEGER PRIMARY KEY CHECK (id = 1),
+        mode INTEGER NOT NULL,
+        start DATETIME NOT NULL,
+        expires DATETIME NOT NULL,
+        reason TEXT NOT NULL DEFAULT '',
+        author TEXT NOT NULL DEFAULT '',
+        created_at DATETIME NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS users (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        username TEXT NOT NULL UNIQUE,
+        password_hash TEXT NOT NULL,
+        role TEXT NOT NULL,
+        created_at DATETIME NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS sessions (
+        token_hash TEXT PRIMARY KEY,
+        user_id INTEGER NOT NULL,
+        expires_at DATETIME NOT NULL
+    );
+
+    CREATE TABLE IF NOT EXISTS api_tokens (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL,
+        token_hash TEXT NOT NULL UNIQUE,
+        role TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        last_used DATETIME
+    );
+
+    CREATE INDEX IF NOT EXISTS idx_schedule_revision_changes ON schedule_revision_changes(revision_id);
     CREATE INDEX IF NOT EXISTS idx_schedule_timestamp ON schedule(timestamp);
     CREATE INDEX IF NOT EXISTS idx_prices_timestamp ON prices(timestamp);
     `
 
-	_, err := d.db.Exec(schema)
+	if _, err := d.db.Exec(schema); err != nil {
+		return err
+	}
+
+	// power_estimates skrevs aldrig i första versionen och byggs om med utfärdandetid
+	hasIssuedAt, err := d.hasColumn("power_estimates", "issued_at")
+	if err != nil {
+		return err
+	}
+	if !hasIssuedAt {
+		if _, err := d.db.Exec("DROP TABLE power_estimates"); err != nil {
+			return err
+		}
+		if _, err := d.db.Exec(schema); err != nil {
+			return err
+		}
+	}
+
+	// Schemat som fanns innan versioner sparades blir första versionen
+	if err := d.migrateScheduleRevisions(); err != nil {
+		return err
+	}
+
+	// Kolumner som lagts till efter första versionen
+	columns := []struct{ table, column, definition string }{
+		{"prices", "source", "TEXT"},
+		{"prices", "eur_mwh", "REAL"},
+		{"history", "event", "TEXT"},
+		{"history", "detail", "TEXT"},
+	}
+	for _, col := range columns {
+		if err := d.addColumnIfMissing(col.table, col.column, col.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing lägger till en kolumn i en befintlig tabell om den saknas
+func (d *Database) addColumnIfMissing(table, column, definition string) error {
+	exists, err := d.hasColumn(table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = d.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
 
+// hasColumn avgör om en tabell har en viss kolumn
+func (d *Database) hasColumn(table, column string) (bool, error) {
+	rows, err := d.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
 // SavePrices sparar en batch av priser
 func (d *Database) SavePrices(prices []models.Price) error {
 	tx, err := d.db.Begin()
@@ -86,14 +279,14 @@ func (d *Database) SavePrices(prices []models.Price) error {
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare("INSERT OR REPLACE INTO prices (timestamp, price_ore, area) VALUES (?, ?, ?)")
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO prices (timestamp, price_ore, area, source, eur_mwh) VALUES (?, ?, ?, ?, ?)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
 	for _, p := range prices {
-		_, err := stmt.Exec(p.Timestamp, p.PriceOre, p.Area)
+		_, err := stmt.Exec(p.Timestamp, p.PriceOre, p.Area, p.Source, p.PriceEurMWh)
 		if err != nil {
 			return err
 		}
@@ -105,7 +298,7 @@ func (d *Database) SavePrices(prices []models.Price) error {
 // GetPrices hämtar priser för ett tidsintervall
 func (d *Database) GetPrices(from, to time.Time) ([]models.Price, error) {
 	rows, err := d.db.Query(
-		"SELECT timestamp, price_ore, area FROM prices WHERE timestamp >= ? AND timestamp < ? ORDER BY timestamp",
+		"SELECT timestamp, price_ore, area, COALESCE(source, ''), eur_mwh FROM prices WHERE timestamp >= ? AND timestamp < ? ORDER BY timestamp",
 		from, to,
 	)
 	if err != nil {
@@ -116,84 +309,985 @@ func (d *Database) GetPrices(from, to time.Time) ([]models.Price, error) {
 	var prices []models.Price
 	for rows.Next() {
 		var p models.Price
-		if err := rows.Scan(&p.Timestamp, &p.PriceOre, &p.Area); err != nil {
+		var eurMWh sql.NullFloat64
+		if err := rows.Scan(&p.Timestamp, &p.PriceOre, &p.Area, &p.Source, &eurMWh); err != nil {
 			return nil, err
 		}
+		if eurMWh.Valid {
+			p.PriceEurMWh = &eurMWh.Float64
+		}
 		prices = append(prices, p)
 	}
 
 	return prices, rows.Err()
 }
 
-// SaveSchedule sparar ett helt nytt schema (ersätter gammalt)
-func (d *Database) SaveSchedule(changes []models.ScheduleChange) error {
+// SaveExchangeRates sparar växelkurser per dag (YYYY-MM-DD -> kurs per EUR)
+func (d *Database) SaveExchangeRates(currency string, rates map[string]float64) error {
 	tx, err := d.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	// Ta bort gammalt schema
-	_, err = tx.Exec("DELETE FROM schedule")
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO exchange_rates (date, currency, rate) VALUES (?, ?, ?)")
 	if err != nil {
 		return err
 	}
+	defer stmt.Close()
 
-	// Lägg till nya breakpoints
-	stmt, err := tx.Prepare("INSERT INTO schedule (timestamp, mode) VALUES (?, ?)")
+	for date, rate := range rates {
+		if _, err := stmt.Exec(date, currency, rate); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetExchangeRate hämtar senaste kursen på eller före ett datum (YYYY-MM-DD).
+// Returnerar kurs 0 om ingen kurs finns.
+func (d *Database) GetExchangeRate(currency, date string) (float64, string, error) {
+	var rate float64
+	var rateDate string
+	err := d.db.QueryRow(
+		"SELECT rate, date FROM exchange_rates WHERE currency = ? AND date <= ? ORDER BY date DESC LIMIT 1",
+		currency, date,
+	).Scan(&rate, &rateDate)
+	if err == sql.ErrNoRows {
+		return 0, "", nil
+	}
+	return rate, rateDate, err
+}
+
+// GetExchangeRates hämtar de senaste kurserna (YYYY-MM-DD -> kurs)
+func (d *Database) GetExchangeRates(currency string, limit int) (map[string]float64, error) {
+	rows, err := d.db.Query(
+		"SELECT date, rate FROM exchange_rates WHERE currency = ? ORDER BY date DESC LIMIT ?",
+		currency, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := make(map[string]float64)
+	for rows.Next() {
+		var date string
+		var rate float64
+		if err := rows.Scan(&date, &rate); err != nil {
+			return nil, err
+		}
+		rates[date] = rate
+	}
+
+	return rates, rows.Err()
+}
+
+// GetTariffComponents hämtar alla tariffkomponenter
+func (d *Database) GetTariffComponents() ([]models.TariffComponent, error) {
+	rows, err := d.db.Query(
+		"SELECT id, name, kind, type, ore_per_kwh, start_hour, end_hour, weekdays, months, enabled FROM tariff_components ORDER BY id",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var components []models.TariffComponent
+	for rows.Next() {
+		var c models.TariffComponent
+		var startHour, endHour sql.NullInt64
+		var weekdays, months string
+		if err := rows.Scan(&c.ID, &c.Name, &c.Kind, &c.Type, &c.OrePerKWh, &startHour, &endHour, &weekdays, &months, &c.Enabled); err != nil {
+			return nil, err
+		}
+		if startHour.Valid && endHour.Valid {
+			start, end := int(startHour.Int64), int(endHour.Int64)
+			c.StartHour, c.EndHour = &start, &end
+		}
+		if c.Weekdays, err = parseIntList(weekdays); err != nil {
+			return nil, err
+		}
+		if c.Months, err = parseIntList(months); err != nil {
+			return nil, err
+		}
+		components = append(components, c)
+	}
+
+	return components, rows.Err()
+}
+
+// SaveTariffComponent skapar (ID 0) eller uppdaterar en tariffkomponent och returnerar dess ID
+func (d *Database) SaveTariffComponent(c models.TariffComponent) (int, error) {
+	if c.ID == 0 {
+		res, err := d.db.Exec(
+			"INSERT INTO tariff_components (name, kind, type, ore_per_kwh, start_hour, end_hour, weekdays, months, enabled) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			c.Name, c.Kind, c.Type, c.OrePerKWh, c.StartHour, c.EndHour, formatIntList(c.Weekdays), formatIntList(c.Months), c.Enabled,
+		)
+		if err != nil {
+			return 0, err
+		}
+		id, err := res.LastInsertId()
+		return int(id), err
+	}
+
+	res, err := d.db.Exec(
+		"UPDATE tariff_components SET name = ?, kind = ?, type = ?, ore_per_kwh = ?, start_hour = ?, end_hour = ?, weekdays = ?, months = ?, enabled = ? WHERE id = ?",
+		c.Name, c.Kind, c.Type, c.OrePerKWh, c.StartHour, c.EndHour, formatIntList(c.Weekdays), formatIntList(c.Months), c.Enabled, c.ID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return c.ID, nil
+}
+
+// DeleteTariffComponent tar bort en tariffkomponent
+func (d *Database) DeleteTariffComponent(id int) error {
+	res, err := d.db.Exec("DELETE FROM tariff_components WHERE id = ?", id)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
 
-	for _, change := range changes {
-		_, err := stmt.Exec(change.Timestamp, change.Mode)
+// SaveModeOverride sparar manuellt läge så att det överlever omstart. nil tar bort det.
+func (d *Database) SaveModeOverride(o *models.ModeOverride) error {
+	if o == nil {
+		_, err := d.db.Exec("DELETE FROM mode_override")
+		return err
+	}
+	_, err := d.db.Exec(
+		"INSERT OR REPLACE INTO mode_override (id, mode, start, expires, reason, author, created_at) VALUES (1, ?, ?, ?, ?, ?, ?)",
+		o.Mode, o.Start, o.Expires, o.Reason, o.Author, o.CreatedAt,
+	)
+	return err
+}
+
+// GetModeOverride hämtar sparat manuellt läge, eller nil om inget finns
+func (d *Database) GetModeOverride() (*models.ModeOverride, error) {
+	var o models.ModeOverride
+	err := d.db.QueryRow(
+		"SELECT mode, start, expires, reason, author, created_at FROM mode_override WHERE id = 1",
+	).Scan(&o.Mode, &o.Start, &o.Expires, &o.Reason, &o.Author, &o.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// GetUsers hämtar alla användare
+func (d *Database) GetUsers() ([]models.User, error) {
+	rows, err := d.db.Query("SELECT id, username, role, created_at FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Role, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// GetUserCredentials hämtar en användare och dess lösenordshash. Returnerar sql.ErrNoRows om den saknas.
+func (d *Database) GetUserCredentials(username string) (models.User, string, error) {
+	var u models.User
+	var hash string
+	err := d.db.QueryRow(
+		"SELECT id, username, role, created_at, password_hash FROM users WHERE username = ?", username,
+	).Scan(&u.ID, &u.Username, &u.Role, &u.CreatedAt, &hash)
+	return u, hash, err
+}
+
+// SaveUser lägger till (ID 0) eller uppdaterar en användare. Tom passwordHash behåller lösenordet
+// vid uppdatering. Returnerar sql.ErrNoRows om ID saknas.
+func (d *Database) SaveUser(u models.User, passwordHash string) (int, error) {
+	if u.ID == 0 {
+		res, err := d.db.Exec(
+			"INSERT INTO users (username, password_hash, role, created_at) VALUES (?, ?, ?, ?)",
+			u.Username, passwordHash, u.Role, u.CreatedAt.UTC(),
+		)
 		if err != nil {
-			return err
+			return 0, err
 		}
+		id, err := res.LastInsertId()
+		return int(id), err
+	}
+
+	res, err := d.db.Exec(
+		"UPDATE users SET role = ?, password_hash = COALESCE(NULLIF(?, ''), password_hash) WHERE id = ?",
+		u.Role, passwordHash, u.ID,
+	)
+	if err != nil {
+		return 0, err
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return u.ID, nil
+}
+
+// DeleteUser tar bort en användare och loggar ut den
+func (d *Database) DeleteUser(id int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	res, err := tx.Exec("DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec("DELETE FROM sessions WHERE user_id = ?", id); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
-// GetSchedule hämtar alla schemaändringar
-func (d *Database) GetSchedule() ([]models.ScheduleChange, error) {
-	rows, err := d.db.Query(
-		"SELECT id, timestamp, mode, created_at FROM schedule ORDER BY timestamp",
+// CountAdmins räknar användare med rollen admin
+func (d *Database) CountAdmins() (int, error) {
+	var n int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", models.RoleAdmin).Scan(&n)
+	return n, err
+}
+
+// CreateSession sparar en inloggning. Utgångna inloggningar rensas samtidigt.
+func (d *Database) CreateSession(tokenHash string, userID int, expires time.Time) error {
+	if _, err := d.db.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now().UTC()); err != nil {
+		return err
+	}
+	_, err := d.db.Exec(
+		"INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)",
+		tokenHash, userID, expires.UTC(),
 	)
+	return err
+}
+
+// GetSessionUser hämtar användaren för en inloggning och när den går ut.
+// Returnerar sql.ErrNoRows om inloggningen eller användaren saknas.
+func (d *Database) GetSessionUser(tokenHash string) (models.User, time.Time, error) {
+	var u models.User
+	var expires time.Time
+	err := d.db.QueryRow(`
+		SELECT u.id, u.username, u.role, u.created_at, s.expires_at
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ?`, tokenHash,
+	).Scan(&u.ID, &u.Username, &u.Role, &u.CreatedAt, &expires)
+	return u, expires, err
+}
+
+// DeleteSession loggar ut en inloggning
+func (d *Database) DeleteSession(tokenHash string) error {
+	_, err := d.db.Exec("DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+// GetAPITokens hämtar alla API-nycklar (utan själva nyckeln)
+func (d *Database) GetAPITokens() ([]models.APIToken, error) {
+	rows, err := d.db.Query("SELECT id, name, role, created_at, last_used FROM api_tokens ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var changes []models.ScheduleChange
+	var tokens []models.APIToken
 	for rows.Next() {
-		var c models.ScheduleChange
-		if err := rows.Scan(&c.ID, &c.Timestamp, &c.Mode, &c.CreatedAt); err != nil {
+		t, err := scanAPIToken(rows)
+		if err != nil {
 			return nil, err
 		}
-		changes = append(changes, c)
+		tokens = append(tokens, t)
 	}
 
-	return changes, rows.Err()
+	return tokens, rows.Err()
 }
 
-// GetSetting hämtar en inställning
-func (d *Database) GetSetting(key string) (string, error) {
-	var value string
-	err := d.db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
-	if err == sql.ErrNoRows {
-		return "", nil
+// GetAPITokenByHash hämtar API-nyckeln med en viss hash. Returnerar sql.ErrNoRows om den saknas.
+func (d *Database) GetAPITokenByHash(tokenHash string) (models.APIToken, error) {
+	return scanAPIToken(d.db.QueryRow(
+		"SELECT id, name, role, created_at, last_used FROM api_tokens WHERE token_hash = ?", tokenHash,
+	))
+}
+
+func scanAPIToken(row interface{ Scan(...any) error }) (models.APIToken, error) {
+	var t models.APIToken
+	var lastUsed sql.NullTime
+	if err := row.Scan(&t.ID, &t.Name, &t.Role, &t.CreatedAt, &lastUsed); err != nil {
+		return t, err
 	}
-	return value, err
+	if lastUsed.Valid {
+		t.LastUsed = &lastUsed.Time
+	}
+	return t, nil
 }
 
-// SaveSetting sparar en inställning
-func (d *Database) SaveSetting(key, value string) error {
-	_, err := d.db.Exec("INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)", key, value)
+// CreateAPIToken sparar en ny API-nyckel
+func (d *Database) CreateAPIToken(t models.APIToken, tokenHash string) (int, error) {
+	res, err := d.db.Exec(
+		"INSERT INTO api_tokens (name, token_hash, role, created_at) VALUES (?, ?, ?, ?)",
+		t.Name, tokenHash, t.Role, t.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// TouchAPIToken noterar när en API-nyckel senast användes
+func (d *Database) TouchAPIToken(id int, t time.Time) error {
+	_, err := d.db.Exec("UPDATE api_tokens SET last_used = ? WHERE id = ?", t.UTC(), id)
 	return err
 }
 
+// DeleteAPIToken tar bort en API-nyckel
+func (d *Database) DeleteAPIToken(id int) error {
+	res, err := d.db.Exec("DELETE FROM api_tokens WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetScheduleTemplates hämtar alla schemamallar
+func (d *Database) GetScheduleTemplates() ([]models.ScheduleTemplate, error) {
+	rows, err := d.db.Query("SELECT id, name, weekdays, entries, enabled FROM schedule_templates ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []models.ScheduleTemplate
+	for rows.Next() {
+		var t models.ScheduleTemplate
+		var weekdays, entries string
+		if err := rows.Scan(&t.ID, &t.Name, &weekdays, &entries, &t.Enabled); err != nil {
+			return nil, err
+		}
+		if t.Weekdays, err = parseIntList(weekdays); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(entries), &t.Entries); err != nil {
+			return nil, fmt.Errorf("invalid entries for schedule template %d: %w", t.ID, err)
+		}
+		templates = append(templates, t)
+	}
+
+	return templates, rows.Err()
+}
+
+// SaveScheduleTemplate lägger till (ID 0) eller uppdaterar en schemamall. Returnerar sql.ErrNoRows om ID saknas.
+func (d *Database) SaveScheduleTemplate(t models.ScheduleTemplate) (int, error) {
+	entries, err := json.Marshal(t.Entries)
+	if err != nil {
+		return 0, err
+	}
+
+	if t.ID == 0 {
+		res, err := d.db.Exec(
+			"INSERT INTO schedule_templates (name, weekdays, entries, enabled) VALUES (?, ?, ?, ?)",
+			t.Name, formatIntList(t.Weekdays), string(entries), t.Enabled,
+		)
+		if err != nil {
+			return 0, err
+		}
+		id, err := res.LastInsertId()
+		return int(id), err
+	}
+
+	res, err := d.db.Exec(
+		"UPDATE schedule_templates SET name = ?, weekdays = ?, entries = ?, enabled = ? WHERE id = ?",
+		t.Name, formatIntList(t.Weekdays), string(entries), t.Enabled, t.ID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	return t.ID, nil
+}
+
+// DeleteScheduleTemplate tar bort en schemamall
+func (d *Database) DeleteScheduleTemplate(id int) error {
+	res, err := d.db.Exec("DELETE FROM schedule_templates WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetTemplateDays returnerar vilka dagar (YYYY-MM-DD) av days som redan fått mallarna utlagda
+func (d *Database) GetTemplateDays(days []string) (map[string]bool, error) {
+	expanded := make(map[string]bool)
+	for _, day := range days {
+		var n int
+		if err := d.db.QueryRow("SELECT COUNT(*) FROM schedule_template_days WHERE day = ?", day).Scan(&n); err != nil {
+			return nil, err
+		}
+		expanded[day] = n > 0
+	}
+	return expanded, nil
+}
+
+// MarkTemplateDays noterar att mallarna lagts ut på days, så att de inte läggs ut igen.
+// revisionID är schemaversionen som skapades, 0 om schemat inte ändrades.
+func (d *Database) MarkTemplateDays(days []string, revisionID int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	revision := sql.NullInt64{Int64: int64(revisionID), Valid: revisionID > 0} // 0 = schemat ändrades inte
+	for _, day := range days {
+		if _, err := tx.Exec(
+			"INSERT OR REPLACE INTO schedule_template_days (day, expanded_at, revision_id) VALUES (?, ?, ?)",
+			day, now, revision,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveHourlyPower sparar uppmätt medeleffekt från nätet för en timme
+func (d *Database) SaveHourlyPower(hour time.Time, avgKW float64, samples int) error {
+	_, err := d.db.Exec(
+		"INSERT OR REPLACE INTO hourly_power (hour, avg_kw, samples) VALUES (?, ?, ?)",
+		hour, avgKW, samples,
+	)
+	return err
+}
+
+// GetHourlyPower hämtar timvärden för ett tidsintervall
+func (d *Database) GetHourlyPower(from, to time.Time) ([]models.HourlyPower, error) {
+	rows, err := d.db.Query(
+		"SELECT hour, avg_kw, samples FROM hourly_power WHERE hour >= ? AND hour < ? ORDER BY hour",
+		from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hourly []models.HourlyPower
+	for rows.Next() {
+		var h models.HourlyPower
+		if err := rows.Scan(&h.Hour, &h.AvgKW, &h.Samples); err != nil {
+			return nil, err
+		}
+		hourly = append(hourly, h)
+	}
+
+	return hourly, rows.Err()
+}
+
+// LogModeTransition loggar ett lägesbyte i history (event t.ex. "mode_change" eller "mode_change_failed")
+func (d *Database) LogModeTransition(t time.Time, mode int, event, detail string) error {
+	_, err := d.db.Exec(
+		"INSERT OR REPLACE INTO history (timestamp, mode, event, detail) VALUES (?, ?, ?, ?)",
+		t, mode, event, detail,
+	)
+	return err
+}
+
+// SaveHistory sparar ett kvartsvärde i history
+func (d *Database) SaveHistory(e models.HistoryEntry) error {
+	_, err := d.db.Exec(
+		"INSERT OR REPLACE INTO history (timestamp, mode, battery_soc, power_kw, price_ore, event, detail) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		e.Timestamp, e.Mode, e.BatterySoC, e.PowerKW, e.PriceOre, e.Event, e.Detail,
+	)
+	return err
+}
+
+// GetHistory hämtar kvartsvärden och lägesbyten för ett tidsintervall
+func (d *Database) GetHistory(from, to time.Time) ([]models.HistoryEntry, error) {
+	rows, err := d.db.Query(
+		"SELECT timestamp, mode, battery_soc, power_kw, price_ore, COALESCE(event, ''), COALESCE(detail, '') FROM history WHERE timestamp >= ? AND timestamp < ? ORDER BY timestamp",
+		from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.HistoryEntry
+	for rows.Next() {
+		var e models.HistoryEntry
+		var soc, power sql.NullFloat64
+		var price sql.NullInt64
+		if err := rows.Scan(&e.Timestamp, &e.Mode, &soc, &power, &price, &e.Event, &e.Detail); err != nil {
+			return nil, err
+		}
+		if soc.Valid {
+			e.BatterySoC = &soc.Float64
+		}
+		if power.Valid {
+			e.PowerKW = &power.Float64
+		}
+		if price.Valid {
+			p := int(price.Int64)
+			e.PriceOre = &p
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+// SaveConsumptionModel sparar en anpassad förbrukningsmodell. Äldre modeller behålls.
+func (d *Database) SaveConsumptionModel(m models.ConsumptionModel) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec("INSERT INTO consumption_models (fitted_at, model) VALUES (?, ?)", m.FittedAt, string(data))
+	return err
+}
+
+// GetConsumptionModel hämtar senast anpassade förbrukningsmodell, eller nil om ingen finns
+func (d *Database) GetConsumptionModel() (*models.ConsumptionModel, error) {
+	var data string
+	err := d.db.QueryRow("SELECT model FROM consumption_models ORDER BY fitted_at DESC, id DESC LIMIT 1").Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m models.ConsumptionModel
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return nil, fmt.Errorf("invalid stored consumption model: %w", err)
+	}
+	return &m, nil
+}
+
+// SavePowerEstimates sparar en utfärdad förbrukningsprognos. Prognoser utfärdade före keepFrom tas bort.
+func (d *Database) SavePowerEstimates(issuedAt time.Time, estimates []models.PowerEstimate, keepFrom time.Time) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO power_estimates (issued_at, timestamp, power_kw, solar_kw, temperature) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range estimates {
+		if _, err := stmt.Exec(issuedAt, e.Timestamp, e.PowerKW, e.SolarKW, e.Temperature); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM power_estimates WHERE issued_at < ?", keepFrom); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetIssuedPowerEstimates hämtar alla sparade prognoser för kvartar i ett tidsintervall
+func (d *Database) GetIssuedPowerEstimates(from, to time.Time) ([]models.IssuedPowerEstimate, error) {
+	rows, err := d.db.Query(
+		"SELECT issued_at, timestamp, power_kw, solar_kw, temperature FROM power_estimates WHERE timestamp >= ? AND timestamp < ? ORDER BY timestamp, issued_at",
+		from, to,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var estimates []models.IssuedPowerEstimate
+	for rows.Next() {
+		var e models.IssuedPowerEstimate
+		var temperature sql.NullFloat64
+		if err := rows.Scan(&e.IssuedAt, &e.Timestamp, &e.PowerKW, &e.SolarKW, &temperature); err != nil {
+			return nil, err
+		}
+		if temperature.Valid {
+			e.Temperature = &temperature.Float64
+		}
+		e.NetKW = e.PowerKW - e.SolarKW
+		estimates = append(estimates, e)
+	}
+
+	return estimates, rows.Err()
+}
+
+// SaveForecast sparar en hämtad väderprognos för en plats. Hämtningar äldre än keep tas bort.
+func (d *Database) SaveForecast(fetchedAt, approvedTime time.Time, lat, lon float64, points []models.ForecastPoint, keep time.Duration) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		"INSERT INTO forecast_fetches (fetched_at, approved_time, lat, lon) VALUES (?, ?, ?, ?)",
+		fetchedAt, approvedTime, lat, lon,
+	)
+	if err != nil {
+		return err
+	}
+	fetchID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT OR REPLACE INTO weather_forecast (fetch_id, time, temperature, cloud_cover, global_radiation) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, p := range points {
+		if _, err := stmt.Exec(fetchID, p.Time, p.Temperature, p.CloudCover, p.GlobalRadiation); err != nil {
+			return err
+		}
+	}
+
+	cutoff := fetchedAt.Add(-keep)
+	if _, err := tx.Exec("DELETE FROM weather_forecast WHERE fetch_id IN (SELECT id FROM forecast_fetches WHERE fetched_at < ?)", cutoff); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM forecast_fetches WHERE fetched_at < ?", cutoff); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetLatestForecast hämtar senast sparade väderprognos för en plats.
+// Returnerar noll-tider och inga punkter om ingen prognos finns.
+func (d *Database) GetLatestForecast(lat, lon float64) (time.Time, time.Time, []models.ForecastPoint, error) {
+	var fetchID int64
+	var fetchedAt, approvedTime time.Time
+	err := d.db.QueryRow(
+		"SELECT id, fetched_at, approved_time FROM forecast_fetches WHERE ABS(lat - ?) < 0.000001 AND ABS(lon - ?) < 0.000001 ORDER BY fetched_at DESC, id DESC LIMIT 1",
+		lat, lon,
+	).Scan(&fetchID, &fetchedAt, &approvedTime)
+	if err == sql.ErrNoRows {
+		return time.Time{}, time.Time{}, nil, nil
+	}
+	if err != nil {
+		return time.Time{}, time.Time{}, nil, err
+	}
+
+	rows, err := d.db.Query(
+		"SELECT time, temperature, cloud_cover, global_radiation FROM weather_forecast WHERE fetch_id = ? ORDER BY time",
+		fetchID,
+	)
+	if err != nil {
+		return time.Time{}, time.Time{}, nil, err
+	}
+	defer rows.Close()
+
+	var points []models.ForecastPoint
+	for rows.Next() {
+		var p models.ForecastPoint
+		var cloudCover, radiation sql.NullFloat64
+		if err := rows.Scan(&p.Time, &p.Temperature, &cloudCover, &radiation); err != nil {
+			return time.Time{}, time.Time{}, nil, err
+		}
+		if cloudCover.Valid {
+			p.CloudCover = &cloudCover.Float64
+		}
+		if radiation.Valid {
+			p.GlobalRadiation = &radiation.Float64
+		}
+		points = append(points, p)
+	}
+
+	return fetchedAt, approvedTime, points, rows.Err()
+}
+
+// SaveSchedule sparar ett helt nytt schema som en ny version och gör det till aktuellt schema.
+// Source, Author och RestoredFrom tas från revision. Returnerar versionens ID.
+func (d *Database) SaveSchedule(changes []models.ScheduleChange, revision models.ScheduleRevision) (int, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	revisionID, err := insertScheduleRevision(tx, changes, revision)
+	if err != nil {
+		return 0, err
+	}
+
+	// Ta bort gammalt schema
+	_, err = tx.Exec("DELETE FROM schedule")
+	if err != nil {
+		return 0, err
+	}
+
+	// Lägg till nya breakpoints. Tider sparas i UTC så att ORDER BY timestamp sorterar rätt.
+	stmt, err := tx.Prepare("INSERT INTO schedule (timestamp, mode) VALUES (?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, change := range changes {
+		_, err := stmt.Exec(change.Timestamp.UTC(), change.Mode)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return revisionID, tx.Commit()
+}
+
+// insertScheduleRevision sparar en oföränderlig kopia av schemat
+func insertScheduleRevision(tx *sql.Tx, changes []models.ScheduleChange, revision models.ScheduleRevision) (int, error) {
+	res, err := tx.Exec(
+		"INSERT INTO schedule_revisions (created_at, source, author, restored_from) VALUES (?, ?, ?, ?)",
+		time.Now(), revision.Source, revision.Author, revision.RestoredFrom,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO schedule_revision_changes (revision_id, timestamp, mode) VALUES (?, ?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for _, change := range changes {
+		if _, err := stmt.Exec(id, change.Timestamp.UTC(), change.Mode); err != nil {
+			return 0, err
+		}
+	}
+
+	return int(id), nil
+}
+
+// migrateScheduleRevisions sparar befintligt schema som första version om inga versioner finns
+func (d *Database) migrateScheduleRevisions() error {
+	var revisions int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM schedule_revisions").Scan(&revisions); err != nil {
+		return err
+	}
+	if revisions > 0 {
+		return nil
+	}
+
+	schedule, err := d.GetSchedule()
+	if err != nil || len(schedule) == 0 {
+		return err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := insertScheduleRevision(tx, schedule, models.ScheduleRevision{Source: models.ScheduleSourceMigration}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetScheduleRevisions hämtar de senaste schemaversionerna (utan brytpunkter), nyast först
+func (d *Database) GetScheduleRevisions(limit int) ([]models.ScheduleRevision, error) {
+	rows, err := d.db.Query(`
+        SELECT r.id, r.created_at, r.source, r.author, r.restored_from,
+               (SELECT COUNT(*) FROM schedule_revision_changes c WHERE c.revision_id = r.id)
+        FROM schedule_revisions r
+        ORDER BY r.id DESC
+        LIMIT ?`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []models.ScheduleRevision
+	for rows.Next() {
+		r, err := scanScheduleRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+
+	return revisions, rows.Err()
+}
+
+// GetLatestScheduleRevisionID returnerar ID för senaste schemaversionen, 0 om ingen finns
+func (d *Database) GetLatestScheduleRevisionID() (int, error) {
+	var id int
+	err := d.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM schedule_revisions`).Scan(&id)
+	return id, err
+}
+
+// GetScheduleRevision hämtar en schemaversion med alla brytpunkter. Returnerar sql.ErrNoRows om den saknas.
+func (d *Database) GetScheduleRevision(id int) (*models.ScheduleRevision, error) {
+	row := d.db.QueryRow(`
+        SELECT r.id, r.created_at, r.source, r.author, r.restored_from,
+               (SELECT COUNT(*) FROM schedule_revision_changes c WHERE c.revision_id = r.id)
+        FROM schedule_revisions r
+        WHERE r.id = ?`,
+		id,
+	)
+	revision, err := scanScheduleRevision(row)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.db.Query(
+		"SELECT timestamp, mode FROM schedule_revision_changes WHERE revision_id = ? ORDER BY timestamp",
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revision.Schedule = []models.ScheduleChange{}
+	for rows.Next() {
+		var c models.ScheduleChange
+		if err := rows.Scan(&c.Timestamp, &c.Mode); err != nil {
+			return nil, err
+		}
+		c.CreatedAt = revision.CreatedAt
+		revision.Schedule = append(revision.Schedule, c)
+	}
+
+	return &revision, rows.Err()
+}
+
+// scanScheduleRevision läser en rad från schedule_revisions (med antal brytpunkter)
+func scanScheduleRevision(row interface{ Scan(...any) error }) (models.ScheduleRevision, error) {
+	var r models.ScheduleRevision
+	var restoredFrom sql.NullInt64
+	if err := row.Scan(&r.ID, &r.CreatedAt, &r.Source, &r.Author, &restoredFrom, &r.Breakpoints); err != nil {
+		return r, err
+	}
+	if restoredFrom.Valid {
+		id := int(restoredFrom.Int64)
+		r.RestoredFrom = &id
+	}
+	return r, nil
+}
+
+// GetSchedule hämtar alla schemaändringar
+func (d *Database) GetSchedule() ([]models.ScheduleChange, error) {
+	rows, err := d.db.Query(
+		"SELECT id, timestamp, mode, created_at FROM schedule ORDER BY timestamp",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []models.ScheduleChange
+	for rows.Next() {
+		var c models.ScheduleChange
+		if err := rows.Scan(&c.ID, &c.Timestamp, &c.Mode, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Rader från före UTC-lagringen har lokal tidszon, så ORDER BY sorterar dem inte alltid rätt
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Timestamp.Before(changes[j].Timestamp)
+	})
+	return changes, nil
+}
+
+// GetSetting hämtar en inställning. Hemliga inställningar dekrypteras.
+func (d *Database) GetSetting(key string) (string, error) {
+	var value string
+	err := d.db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return d.decryptSetting(key, value)
+}
+
+// SaveSetting sparar en inställning. Hemliga inställningar krypteras om SetSecretKey anropats.
+func (d *Database) SaveSetting(key, value string) error {
+	value, err := d.encryptSetting(key, value)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec("INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)", key, value)
+	return err
+}
+
+// parseIntList tolkar en kommaseparerad lista av heltal ("1,2,3")
+func parseIntList(value string) ([]int, error) {
+	var result []int
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer list %q: %w", value, err)
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// formatIntList formaterar heltal som kommaseparerad lista
+func formatIntList(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
+
 // Close stänger databasanslutningen
 func (d *Database) Close() error {
 	return d.db.Close()
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// SecretSettings är inställningar som krypteras i databasen och aldrig visas i klartext i API:t
+var SecretSettings = map[string]bool{
+	"entsoe_token": true,
+	"ha_token":     true,
+	"pushover_app": true,
+}
+
+// encryptedPrefix markerar ett krypterat värde i settings-tabellen
+const encryptedPrefix = "enc:v1:"
+
+// SetSecretKey slår på kryptering av hemliga inställningar. AES-256-nyckeln härleds från key med SHA-256.
+func (d *Database) SetSecretKey(key string) error {
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	d.secrets = gcm
+	return nil
+}
+
+// EncryptSecretSettings krypterar hemliga inställningar som sparats i klartext och kontrollerar
+// att redan krypterade går att läsa med nyckeln. Returnerar hur många som krypterades.
+func (d *Database) EncryptSecretSettings() (int, error) {
+	encrypted := 0
+	for key := range SecretSettings {
+		var stored string
+		err := d.db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&stored)
+		if err != nil {
+			continue // Saknas
+		}
+
+		value, err := d.decryptSetting(key, stored)
+		if err != nil {
+			return encrypted, err
+		}
+		if strings.HasPrefix(stored, encryptedPrefix) || value == "" {
+			continue
+		}
+		if err := d.SaveSetting(key, value); err != nil {
+			return encrypted, err
+		}
+		encrypted++
+	}
+	return encrypted, nil
+}
+
+// encryptSetting krypterar value om key är hemlig och en nyckel är satt. Namnet på inställningen
+// ingår i krypteringen, så ett värde kan inte flyttas till en annan inställning.
+func (d *Database) encryptSetting(key, value string) (string, error) {
+	if d.secrets == nil || !SecretSettings[key] || value == "" {
+		return value, nil
+	}
+
+	nonce := make([]byte, d.secrets.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := d.secrets.Seal(nonce, nonce, []byte(value), []byte(key))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSetting returnerar klartexten för ett sparat värde. Okrypterade värden returneras som de är.
+func (d *Database) decryptSetting(key, stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, encryptedPrefix)
+	if !ok {
+		return stored, nil
+	}
+	if d.secrets == nil {
+		return "", fmt.Errorf("inställningen %s är krypterad men SETTINGS_KEY saknas", key)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < d.secrets.NonceSize() {
+		return "", fmt.Errorf("inställningen %s är skadad", key)
+	}
+	nonce, ciphertext := sealed[:d.secrets.NonceSize()], sealed[d.secrets.NonceSize():]
+	value, err := d.secrets.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return "", fmt.Errorf("inställningen %s kan inte dekrypteras, fel SETTINGS_KEY?", key)
+	}
+	return string(value), nil
+}
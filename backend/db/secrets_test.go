@@ -0,0 +1,129 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// newMemoryDatabase öppnar en tom databas i minnet, delad mellan anslutningarna i poolen
+func newMemoryDatabase(t *testing.T) *Database {
+	t.Helper()
+	d, err := NewDatabase(fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// storedSetting läser värdet som det ligger i settings-tabellen
+func storedSetting(t *testing.T, d *Database, key string) string {
+	t.Helper()
+	var value string
+	if err := d.db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestSecretSettingRoundTrip(t *testing.T) {
+	d := newMemoryDatabase(t)
+	if err := d.SetSecretKey("nyckel"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.SaveSetting("ha_token", "abc123"); err != nil {
+		t.Fatal(err)
+	}
+	if stored := storedSetting(t, d, "ha_token"); !strings.HasPrefix(stored, encryptedPrefix) || strings.Contains(stored, "abc123") {
+		t.Fatalf("stored value %q is not encrypted", stored)
+	}
+	if value, err := d.GetSetting("ha_token"); err != nil || value != "abc123" {
+		t.Fatalf("GetSetting = %q, %v, want abc123", value, err)
+	}
+
+	// Vanliga inställningar krypteras inte
+	if err := d.SaveSetting("min_soc", "15"); err != nil {
+		t.Fatal(err)
+	}
+	if stored := storedSetting(t, d, "min_soc"); stored != "15" {
+		t.Fatalf("stored min_soc = %q, want plaintext", stored)
+	}
+}
+
+func TestSecretSettingCannotBeMoved(t *testing.T) {
+	d := newMemoryDatabase(t)
+	if err := d.SetSecretKey("nyckel"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SaveSetting("entsoe_token", "abc123"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Inställningens namn ingår i krypteringen, så värdet går inte att läsa under ett annat namn
+	if _, err := d.db.Exec("INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)", "ha_token", storedSetting(t, d, "entsoe_token")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := d.GetSetting("ha_token"); err == nil {
+		t.Fatalf("moved secret decrypted to %q, want error", value)
+	}
+}
+
+func TestSecretSettingWrongKey(t *testing.T) {
+	d := newMemoryDatabase(t)
+	if err := d.SetSecretKey("nyckel"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SaveSetting("ha_token", "abc123"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.SetSecretKey("fel-nyckel"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.GetSetting("ha_token"); err == nil {
+		t.Fatal("wrong SETTINGS_KEY decrypted the secret")
+	}
+	if _, err := d.EncryptSecretSettings(); err == nil {
+		t.Fatal("EncryptSecretSettings accepted a wrong SETTINGS_KEY")
+	}
+
+	d.secrets = nil
+	if _, err := d.GetSetting("ha_token"); err == nil {
+		t.Fatal("encrypted secret read without SETTINGS_KEY")
+	}
+}
+
+func TestEncryptSecretSettings(t *testing.T) {
+	d := newMemoryDatabase(t)
+	for key, value := range map[string]string{"entsoe_token": "e-token", "ha_token": "h-token", "min_soc": "15"} {
+		if err := d.SaveSetting(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := d.SetSecretKey("nyckel"); err != nil {
+		t.Fatal(err)
+	}
+	n, err := d.EncryptSecretSettings()
+	if err != nil || n != 2 {
+		t.Fatalf("EncryptSecretSettings = %d, %v, want 2", n, err)
+	}
+	for key, want := range map[string]string{"entsoe_token": "e-token", "ha_token": "h-token"} {
+		if stored := storedSetting(t, d, key); !strings.HasPrefix(stored, encryptedPrefix) {
+			t.Errorf("%s stored as %q, want encrypted", key, stored)
+		}
+		if value, err := d.GetSetting(key); err != nil || value != want {
+			t.Errorf("%s = %q, %v, want %q", key, value, err, want)
+		}
+	}
+	if stored := storedSetting(t, d, "min_soc"); stored != "15" {
+		t.Errorf("min_soc stored as %q, want plaintext", stored)
+	}
+
+	// Redan krypterade värden räknas inte igen
+	if n, err := d.EncryptSecretSettings(); err != nil || n != 0 {
+		t.Fatalf("second EncryptSecretSettings = %d, %v, want 0", n, err)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -10,6 +11,7 @@ import (
 
 	"battery-scheduler/api"
 	"battery-scheduler/db"
+	"battery-scheduler/models"
 	"battery-scheduler/services"
 )
 
@@ -29,6 +31,22 @@ func main() {
 
 	log.Println("Database initialized")
 
+	// Hemliga inställningar (tokens) krypteras med en nyckel som bara finns i miljön
+	if settingsKey := os.Getenv("SETTINGS_KEY"); settingsKey != "" {
+		if err := database.SetSecretKey(settingsKey); err != nil {
+			log.Fatalf("Failed to set settings key: %v", err)
+		}
+		n, err := database.EncryptSecretSettings()
+		if err != nil {
+			log.Fatalf("Failed to encrypt secret settings: %v", err)
+		}
+		if n > 0 {
+			log.Printf("Encrypted %d secret settings", n)
+		}
+	} else {
+		log.Println("WARNING: SETTINGS_KEY not set, secret settings are stored unencrypted")
+	}
+
 	// Hämta inställningar från miljövariabler först, sedan databas
 	entsoeToken := os.Getenv("ENTSOE_TOKEN")
 	if entsoeToken == "" {
@@ -60,18 +78,132 @@ func main() {
 		haToken, _ = database.GetSetting("ha_token")
 	}
 
+	// Effektsensor för nätimport (W eller kW) till effekttariffen
+	gridPowerEntity := os.Getenv("HA_GRID_POWER_ENTITY")
+	if gridPowerEntity == "" {
+		gridPowerEntity, _ = database.GetSetting("ha_grid_power_entity")
+	}
+	if gridPowerEntity == "" {
+		gridPowerEntity = "sensor.ferroamp_external_power"
+	}
+
+	// Lägesstyrning: tjänst i Home Assistant som anropas vid varje brytpunkt (tomt = avstängt)
+	modeService := envOrSetting(database, "HA_MODE_SERVICE", "ha_mode_service")
+	modeEntity := envOrSetting(database, "HA_MODE_ENTITY", "ha_mode_entity")
+	modeStateEntity := envOrSetting(database, "HA_MODE_STATE_ENTITY", "ha_mode_state_entity")
+	modeOptions, err := services.ParseModeOptions(envOrSetting(database, "HA_MODE_OPTIONS", "ha_mode_options"))
+	if err != nil {
+		log.Printf("Invalid HA mode options, using mode numbers: %v", err)
+	}
+
+	// Förbrukningsmodell: husets last och utetemperatur i Home Assistant
+	loadEntity := envOrSetting(database, "HA_LOAD_ENTITY", "ha_load_entity")
+	temperatureEntity := envOrSetting(database, "HA_TEMPERATURE_ENTITY", "ha_temperature_entity")
+
 	// SMHI-koordinater (default: Nacka/Stockholm)
-	smhiLat := 59.38309
-	smhiLon := 17.01550
+	smhiLat, smhiLon := services.DefaultSMHILat, services.DefaultSMHILon
+	latValue := envOrSetting(database, "SMHI_LAT", "smhi_lat")
+	lonValue := envOrSetting(database, "SMHI_LON", "smhi_lon")
+	if latValue != "" || lonValue != "" {
+		if lat, lon, err := services.ParseSMHILocation(latValue, lonValue); err != nil {
+			log.Printf("Invalid SMHI location, using default: %v", err)
+		} else {
+			smhiLat, smhiLon = lat, lon
+		}
+	}
+
+	// Prisproviders i prioritetsordning (default: Entsoe, Nord Pool, lokal fil)
+	providerOrder := os.Getenv("PRICE_PROVIDERS")
+	if providerOrder == "" {
+		providerOrder, _ = database.GetSetting("price_providers")
+	}
+	if providerOrder == "" {
+		providerOrder = "entsoe,nordpool,file"
+	}
+	nordPoolURL := os.Getenv("NORDPOOL_URL")
+	if nordPoolURL == "" {
+		nordPoolURL, _ = database.GetSetting("nordpool_url")
+	}
+	priceFile := os.Getenv("PRICE_FILE")
+	if priceFile == "" {
+		priceFile, _ = database.GetSetting("price_file")
+	}
+
+	// Växelkurs EUR/SEK från ECB (URL kan pekas om, t.ex. mot en lokal stub)
+	ecbURL := os.Getenv("ECB_URL")
+	if ecbURL == "" {
+		ecbURL, _ = database.GetSetting("ecb_url")
+	}
+	exchangeRates := services.NewExchangeRateService(ecbURL, database)
+
+	var providers []services.PriceProvider
+	for _, name := range strings.Split(providerOrder, ",") {
+		switch strings.TrimSpace(name) {
+		case "entsoe":
+			providers = append(providers, services.NewEntsoeService(entsoeToken, area, exchangeRates))
+		case "nordpool":
+			providers = append(providers, services.NewNordPoolService(nordPoolURL, area, exchangeRates))
+		case "file":
+			if priceFile != "" {
+				providers = append(providers, services.NewFilePriceService(priceFile, area))
+			}
+		case "":
+		default:
+			log.Printf("Unknown price provider %q, ignoring", name)
+		}
+	}
 
 	// Skapa services
-	entsoeService := services.NewEntsoeService(entsoeToken, area)
+	priceService := services.NewPriceService(providers...)
+	log.Printf("Price providers: %v", priceService.Providers())
 	pushoverService := services.NewPushoverService(pushoverApp, pushoverUser)
 	smhiService := services.NewSMHIService(smhiLat, smhiLon)
+	log.Printf("SMHI forecast location: %.5f, %.5f", smhiLat, smhiLon)
+	forecastStore := services.NewForecastStore(database, smhiService)
 	haService := services.NewHomeAssistantService(haURL, haToken)
+	peakTracker := services.NewPeakTracker(database, haService, gridPowerEntity)
+	consumptionModel := services.NewConsumptionModelService(database, haService, loadEntity, temperatureEntity)
+
+	// Ladda befintligt schema från databasen
+	schedule, _ := database.GetSchedule()
+	scheduler := services.NewSchedulerService(schedule)
+	if override, err := database.GetModeOverride(); err != nil {
+		log.Printf("Failed to load mode override: %v", err)
+	} else if override != nil && time.Now().Before(override.Expires) {
+		scheduler.SetOverride(*override)
+		log.Printf("Mode override %d active until %s", override.Mode, override.Expires.Format(time.RFC3339))
+	}
+
+	var executor *services.ModeExecutor
+	if haService.Configured() && modeService != "" {
+		executor = services.NewModeExecutor(scheduler, haService, database, services.ExecutorConfig{
+			Service:       modeService,
+			EntityID:      modeEntity,
+			StateEntityID: modeStateEntity,
+			Options:       modeOptions,
+		})
+		go executor.Run()
+		log.Printf("Mode executor started (%s %s)", modeService, modeEntity)
+	}
+
+	recorder := services.NewHistoryRecorder(database, scheduler, haService, gridPowerEntity)
+
+	events := services.NewEventHub(scheduler, haService)
+	go events.Run()
+
+	// Autentisering slås på när första admin skapas (här via miljövariabler eller i API:t)
+	auth := services.NewAuthService(database, strings.Split(envOrSetting(database, "AUTH_PUBLIC_PATHS", "auth_public_paths"), ","))
+	if adminUser, adminPassword := os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD"); adminUser != "" && adminPassword != "" {
+		if err := auth.EnsureAdmin(adminUser, adminPassword); err != nil {
+			log.Fatalf("Failed to create admin user: %v", err)
+		}
+	}
+	if enabled, err := auth.Enabled(); err == nil && !enabled {
+		log.Println("WARNING: No admin user, the API is open without authentication")
+	}
 
 	// Skapa API
-	apiHandler := api.NewAPI(database, entsoeService, pushoverService, smhiService, haService)
+	apiHandler := api.NewAPI(database, priceService, pushoverService, smhiService, forecastStore, haService, peakTracker, consumptionModel, scheduler, executor, events, auth)
 
 	// Sätt upp Gin router
 	router := gin.Default()
@@ -79,8 +211,9 @@ func main() {
 	// CORS middleware för att frontend ska kunna prata med backend
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, X-Schedule-Source, X-Author")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -90,18 +223,73 @@ func main() {
 		c.Next()
 	})
 
-	// API routes
-	apiRoutes := router.Group("/api")
+	// API routes. Läsning kräver viewer, ändringar av schema och läge planner,
+	// inställningar, tariff och användare admin.
+	apiRoutes := router.Group("/api", apiHandler.Authenticate)
 	{
-		apiRoutes.GET("/prices", apiHandler.GetPrices)
-		apiRoutes.GET("/schedule", apiHandler.GetSchedule)
-		apiRoutes.POST("/schedule", apiHandler.SaveSchedule)
-		apiRoutes.GET("/current-mode", apiHandler.GetCurrentMode)
-		apiRoutes.GET("/power-estimate", apiHandler.GetPowerEstimate)
-		apiRoutes.GET("/battery-soc", apiHandler.GetBatterySoC)
-		apiRoutes.POST("/refresh-prices", apiHandler.RefreshPrices)
-		apiRoutes.GET("/settings", apiHandler.GetSettings)
-		apiRoutes.POST("/settings", apiHandler.SaveSettings)
+		apiRoutes.POST("/auth/login", apiHandler.Login)
+		apiRoutes.POST("/auth/logout", apiHandler.Logout)
+		apiRoutes.GET("/auth/me", apiHandler.GetAuthStatus)
+	}
+
+	viewer := apiRoutes.Group("", apiHandler.RequireRole(models.RoleViewer))
+	{
+		viewer.GET("/prices", apiHandler.GetPrices)
+		viewer.GET("/schedule", apiHandler.GetSchedule)
+		viewer.POST("/schedule/validate", apiHandler.ValidateSchedule)
+		viewer.GET("/schedule/revisions", apiHandler.GetScheduleRevisions)
+		viewer.GET("/schedule/revisions/:id", apiHandler.GetScheduleRevision)
+		viewer.GET("/schedule/revisions/:id/diff", apiHandler.DiffScheduleRevisions)
+		viewer.GET("/schedule/templates", apiHandler.GetScheduleTemplates)
+		viewer.GET("/current-mode", apiHandler.GetCurrentMode)
+		viewer.GET("/events", apiHandler.StreamEvents)
+		viewer.GET("/override", apiHandler.GetOverride)
+		viewer.GET("/power-estimate", apiHandler.GetPowerEstimate)
+		viewer.GET("/power-estimate/accuracy", apiHandler.GetPowerEstimateAccuracy)
+		viewer.GET("/forecast", apiHandler.GetForecast)
+		viewer.GET("/consumption-model", apiHandler.GetConsumptionModel)
+		viewer.GET("/battery-soc", apiHandler.GetBatterySoC)
+		viewer.GET("/simulation", apiHandler.GetSimulation)
+		viewer.POST("/simulation", apiHandler.SimulateSchedule)
+		viewer.GET("/exchange-rates", apiHandler.GetExchangeRates)
+		viewer.GET("/tariff", apiHandler.GetTariff)
+		viewer.GET("/peaks", apiHandler.GetPeaks)
+		viewer.GET("/history", apiHandler.GetHistory)
+	}
+
+	planner := apiRoutes.Group("", apiHandler.RequireRole(models.RolePlanner))
+	{
+		planner.POST("/schedule", apiHandler.SaveSchedule)
+		planner.PATCH("/schedule", apiHandler.PatchSchedule)
+		planner.POST("/schedule/optimize", apiHandler.OptimizeSchedule)
+		planner.POST("/schedule/revisions/:id/restore", apiHandler.RestoreScheduleRevision)
+		planner.POST("/schedule/templates", apiHandler.CreateScheduleTemplate)
+		planner.PUT("/schedule/templates/:id", apiHandler.UpdateScheduleTemplate)
+		planner.DELETE("/schedule/templates/:id", apiHandler.DeleteScheduleTemplate)
+		planner.POST("/schedule/templates/expand", apiHandler.ExpandScheduleTemplates)
+		planner.POST("/ev/plan", apiHandler.PlanEVCharging)
+		planner.POST("/override", apiHandler.SetOverride)
+		planner.DELETE("/override", apiHandler.ClearOverride)
+		planner.POST("/consumption-model/fit", apiHandler.FitConsumptionModel)
+		planner.POST("/refresh-prices", apiHandler.RefreshPrices)
+		planner.POST("/peaks/plan", apiHandler.PlanPeaks)
+	}
+
+	admin := apiRoutes.Group("", apiHandler.RequireRole(models.RoleAdmin))
+	{
+		admin.POST("/schedule/purge", apiHandler.PurgeSchedule)
+		admin.POST("/tariff", apiHandler.CreateTariffComponent)
+		admin.PUT("/tariff/:id", apiHandler.UpdateTariffComponent)
+		admin.DELETE("/tariff/:id", apiHandler.DeleteTariffComponent)
+		admin.GET("/settings", apiHandler.GetSettings)
+		admin.POST("/settings", apiHandler.SaveSettings)
+		admin.GET("/auth/users", apiHandler.GetUsers)
+		admin.POST("/auth/users", apiHandler.CreateUser)
+		admin.PUT("/auth/users/:id", apiHandler.UpdateUser)
+		admin.DELETE("/auth/users/:id", apiHandler.DeleteUser)
+		admin.GET("/auth/tokens", apiHandler.GetAPITokens)
+		admin.POST("/auth/tokens", apiHandler.CreateAPIToken)
+		admin.DELETE("/auth/tokens/:id", apiHandler.DeleteAPIToken)
 	}
 
 	// Servera frontend (statiska filer)
@@ -110,6 +298,9 @@ func main() {
 		c.Redirect(302, "/frontend/index.html")
 	})
 
+	// Mätvärden i Prometheus-format (läge, priser, SoC och prognos) att skrapa
+	router.GET("/metrics", apiHandler.Authenticate, apiHandler.RequireRole(models.RoleViewer), apiHandler.GetMetrics)
+
 	// Health check endpoint
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "ok"})
@@ -122,53 +313,84 @@ func main() {
 	c.AddFunc("5 13 * * *", func() {
 		log.Println("Running scheduled price fetch...")
 
-		now := time.Now()
-		startOfToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
-		endOfTomorrow := startOfToday.Add(48 * time.Hour)
-
-		prices, err := entsoeService.FetchPrices(startOfToday, endOfTomorrow)
+		count, source, err := apiHandler.UpdatePrices()
 		if err != nil {
-			log.Printf("Failed to fetch prices from Entsoe: %v", err)
+			log.Printf("Failed to fetch prices: %v", err)
 			return // Avbryt - spara INTE mock-data till databasen
 		}
 
-		if err := database.SavePrices(prices); err != nil {
-			log.Printf("Failed to save prices: %v", err)
-			return
-		}
+		log.Printf("Successfully fetched and saved %d prices from %s", count, source)
+	})
 
-		log.Printf("Successfully fetched and saved %d prices", len(prices))
-
-		// Beräkna statistik
-		if len(prices) > 0 {
-			var sum, min, max int
-			min = prices[0].PriceOre
-			max = prices[0].PriceOre
-
-			for _, p := range prices {
-				sum += p.PriceOre
-				if p.PriceOre < min {
-					min = p.PriceOre
-				}
-				if p.PriceOre > max {
-					max = p.PriceOre
-				}
+	// Effekttariff: sampla nätimport varje minut, timmedel sparas vid timskifte
+	if haService.Configured() {
+		c.AddFunc("* * * * *", func() {
+			if err := peakTracker.Sample(); err != nil {
+				log.Printf("Failed to sample grid power: %v", err)
 			}
+		})
+	}
 
-			avg := sum / len(prices)
+	// SMHI ger ut nya prognoser varje timme, hämta strax efter
+	c.AddFunc("10 * * * *", func() {
+		if err := forecastStore.Refresh(); err != nil {
+			log.Printf("Failed to refresh SMHI forecast, keeping last good: %v", err)
+		}
+	})
+	go func() {
+		if err := forecastStore.Refresh(); err != nil {
+			log.Printf("Failed to fetch SMHI forecast at startup: %v", err)
+		}
+	}()
 
-			// Skicka Pushover-notis
-			appURL, _ := database.GetSetting("app_url")
-			if err := pushoverService.SendPriceUpdateNotification(avg, min, max, appURL); err != nil {
-				log.Printf("Failed to send Pushover notification: %v", err)
-			} else {
-				log.Println("Pushover notification sent successfully")
-			}
+	// Spara utfärdad förbrukningsprognos varje timme efter ny väderprognos, för träffsäkerhetsstatistik
+	c.AddFunc("15 * * * *", func() {
+		if _, err := apiHandler.RecordPowerEstimates(); err != nil {
+			log.Printf("Failed to record power estimates: %v", err)
 		}
 	})
 
+	// Spara faktiskt läge, SoC, medeleffekt och pris för kvarten som just tagit slut
+	c.AddFunc("*/15 * * * *", func() {
+		if err := recorder.Record(time.Now()); err != nil {
+			log.Printf("Failed to record history: %v", err)
+		}
+	})
+
+	// Rensa gamla brytpunkter ur schemat varje natt, de finns kvar i schemaversionerna
+	c.AddFunc("45 3 * * *", func() {
+		removed, revisionID, err := apiHandler.PurgeOldBreakpoints()
+		if err != nil {
+			log.Printf("Failed to purge old schedule breakpoints: %v", err)
+			return
+		}
+		if removed > 0 {
+			log.Printf("Purged %d old schedule breakpoints (revision %d, earlier revisions keep them)", removed, revisionID)
+		}
+	})
+
+	// ECB publicerar dagens referenskurser ca 16:00 CET
+	c.AddFunc("30 16 * * 1-5", func() {
+		if err := exchangeRates.Refresh(); err != nil {
+			log.Printf("Failed to refresh exchange rates: %v", err)
+		}
+	})
+
+	// Anpassa förbrukningsmodellen mot de senaste fyra veckorna varje måndag natt
+	if haService.Configured() && temperatureEntity != "" {
+		c.AddFunc("0 3 * * 1", func() {
+			to := time.Now().Truncate(15 * time.Minute)
+			model, err := consumptionModel.Fit(to.AddDate(0, 0, -28), to)
+			if err != nil {
+				log.Printf("Failed to fit consumption model: %v", err)
+				return
+			}
+			log.Printf("Consumption model fitted on %d quarters (RMSE %.2f kW, default curve %.2f kW)", model.Samples, model.RMSEKW, model.DefaultRMSEKW)
+		})
+	}
+
 	c.Start()
-	log.Println("Cron scheduler started (price fetch at 13:05 daily)")
+	log.Println("Cron scheduler started (price fetch at 13:05 daily, exchange rates at 16:30 weekdays)")
 
 	// Starta servern
 	port := os.Getenv("PORT")
@@ -181,3 +403,12 @@ func main() {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// envOrSetting läser en miljövariabel med fallback till en inställning i databasen
+func envOrSetting(database *db.Database, env, key string) string {
+	if value := os.Getenv(env); value != "" {
+		return value
+	}
+	value, _ := database.GetSetting(key)
+	return value
+}
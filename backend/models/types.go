@@ -4,9 +4,34 @@ import "time"
 
 // Price representerar ett elpris för ett kvart
 type Price struct {
-	Timestamp time.Time `json:"timestamp"`
-	PriceOre  int       `json:"price"` // Pris i öre inkl moms
-	Area      string    `json:"area"`  // SE1, SE2, SE3, SE4
+	Timestamp   time.Time `json:"timestamp"`
+	PriceOre    int       `json:"price"`                   // Pris i öre inkl moms
+	PriceEurMWh *float64  `json:"price_eur_mwh,omitempty"` // Råpris från börsen, för omräkning med annan kurs
+	Area        string    `json:"area"`                    // SE1, SE2, SE3, SE4
+	Source      string    `json:"source,omitempty"`        // Prisprovider som levererade priset
+	TotalOre    *int      `json:"total_price,omitempty"`   // Spotpris + skatt, nätavgift och påslag inkl moms
+}
+
+// Tariffkomponenttyper
+const (
+	TariffFlat      = "flat"        // Gäller alltid
+	TariffTimeOfUse = "time_of_use" // Gäller vissa timmar (och ev. veckodagar/månader)
+	TariffSeasonal  = "seasonal"    // Gäller vissa månader (och ev. timmar)
+)
+
+// TariffComponent är en del av elkostnaden utöver spotpriset, t.ex. energiskatt,
+// nätägarens överföringsavgift eller elhandlarens påslag
+type TariffComponent struct {
+	ID        int     `json:"id"`
+	Name      string  `json:"name"`
+	Kind      string  `json:"kind"`                 // energy_tax, grid_fee, markup
+	Type      string  `json:"type"`                 // flat, time_of_use, seasonal
+	OrePerKWh float64 `json:"ore_per_kwh"`          // Exkl moms
+	StartHour *int    `json:"start_hour,omitempty"` // Första timmen (0-23), lokal tid
+	EndHour   *int    `json:"end_hour,omitempty"`   // Timmen då komponenten slutar gälla (1-24), får vara < StartHour över midnatt
+	Weekdays  []int   `json:"weekdays,omitempty"`   // 1=måndag ... 7=söndag, tomt = alla dagar
+	Months    []int   `json:"months,omitempty"`     // 1-12, tomt = alla månader
+	Enabled   bool    `json:"enabled"`
 }
 
 // ScheduleChange representerar en ändring i schemat (en breakpoint)
@@ -17,20 +42,200 @@ type ScheduleChange struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
-// PowerEstimate är prognosticerad förbrukning för ett kvart
+// Källor till schemaversioner
+const (
+	ScheduleSourceUI        = "ui"        // Webbgränssnittet
+	ScheduleSourceAPI       = "api"       // Övriga anrop till POST /api/schedule
+	ScheduleSourceOptimizer = "optimizer" // POST /api/schedule/optimize
+	ScheduleSourcePeaks     = "peaks"     // POST /api/peaks/plan
+	ScheduleSourceEV        = "ev"        // POST /api/ev/plan
+	ScheduleSourceTemplate  = "template"  // Återkommande mallar utlagda på nya dagar
+	ScheduleSourceRetention = "retention" // Gamla brytpunkter rensade, finns kvar i tidigare versioner
+	ScheduleSourceRestore   = "restore"   // Återställd från en tidigare version
+	ScheduleSourceMigration = "migration" // Schemat som fanns innan versioner sparades
+)
+
+// ScheduleRevision är en sparad, oföränderlig version av hela schemat
+type ScheduleRevision struct {
+	ID           int              `json:"id"`
+	CreatedAt    time.Time        `json:"created_at"`
+	Source       string           `json:"source"`
+	Author       string           `json:"author,omitempty"`
+	RestoredFrom *int             `json:"restored_from,omitempty"`
+	Breakpoints  int              `json:"breakpoints"`
+	Schedule     []ScheduleChange `json:"schedule,omitempty"`
+}
+
+// ScheduleDiff är skillnaden mellan två schemaversioner, per brytpunkt
+type ScheduleDiff struct {
+	From    int                `json:"from"`
+	To      int                `json:"to"`
+	Added   []ScheduleChange   `json:"added"`   // Brytpunkter som bara finns i to
+	Removed []ScheduleChange   `json:"removed"` // Brytpunkter som bara finns i from
+	Changed []ScheduleModeDiff `json:"changed"` // Samma tidpunkt, annat läge
+}
+
+// ScheduleModeDiff är en brytpunkt vars läge ändrats mellan två versioner
+type ScheduleModeDiff struct {
+	Timestamp time.Time `json:"timestamp"`
+	FromMode  int       `json:"from_mode"`
+	ToMode    int       `json:"to_mode"`
+}
+
+// ScheduleViolation är ett brott mot en valideringsregel för schemat
+type ScheduleViolation struct {
+	Rule      string    `json:"rule"`     // T.ex. mode_range, past_edit
+	Severity  string    `json:"severity"` // error (sparas inte) eller warning
+	Timestamp time.Time `json:"timestamp"`
+	Mode      int       `json:"mode"`
+	Message   string    `json:"message"`
+}
+
+// ScheduleTemplate är ett återkommande dygnsschema som läggs ut på nya dagar när priserna kommer
+type ScheduleTemplate struct {
+	ID       int                     `json:"id"`
+	Name     string                  `json:"name"`
+	Weekdays []int                   `json:"weekdays,omitempty"` // 1=måndag ... 7=söndag, tomt = alla dagar
+	Entries  []ScheduleTemplateEntry `json:"entries"`
+	Enabled  bool                    `json:"enabled"`
+}
+
+// ScheduleTemplateEntry är ett läge under en del av dygnet i en mall
+type ScheduleTemplateEntry struct {
+	Start string `json:"start"` // "HH:MM" lokal tid, hel kvart
+	End   string `json:"end"`   // "HH:MM", "24:00" = midnatt, före Start = över midnatt
+	Mode  int    `json:"mode"`
+}
+
+// PowerEstimate är prognosticerad förbrukning och solelsproduktion för ett kvart
 type PowerEstimate struct {
 	Timestamp   time.Time `json:"timestamp"`
 	PowerKW     float64   `json:"power_kw"`
+	SolarKW     float64   `json:"solar_kw"`              // Prognosticerad solelsproduktion
+	NetKW       float64   `json:"net_kw"`                // Förbrukning minus solel, negativt = överskott
 	Temperature *float64  `json:"temperature,omitempty"` // Utetemperatur i °C
+	CloudCover  *float64  `json:"cloud_cover,omitempty"` // Molnighet i oktas (0-8)
+}
+
+// ConsumptionModel är en förbrukningsmodell anpassad till uppmätt last:
+// baslast per timme och veckodag plus värmebehov under en balanstemperatur.
+// Effekt = HourKW[timme] + WeekdayKW[veckodag] + HeatingLinear*g + HeatingQuadratic*g²,
+// där g är antal grader under BalanceTempC (0 över).
+type ConsumptionModel struct {
+	FittedAt         time.Time   `json:"fitted_at"`
+	From             time.Time   `json:"from"`
+	To               time.Time   `json:"to"`
+	Source           string      `json:"source"`  // history eller home_assistant
+	Samples          int         `json:"samples"` // Antal kvartar modellen anpassades mot
+	BalanceTempC     float64     `json:"balance_temp_c"`
+	HeatingLinear    float64     `json:"heating_linear"`    // kW per grad under balanstemperaturen
+	HeatingQuadratic float64     `json:"heating_quadratic"` // kW per grad²
+	HourKW           [24]float64 `json:"hour_kw"`           // Baslast per timme, lokal tid
+	WeekdayKW        [7]float64  `json:"weekday_kw"`        // Tillägg per veckodag, 0=måndag ... 6=söndag (måndag alltid 0)
+	RMSEKW           float64     `json:"rmse_kw"`
+	MAEKW            float64     `json:"mae_kw"`
+	DefaultRMSEKW    float64     `json:"default_rmse_kw"` // Samma mått för den fasta temperaturkurvan, som jämförelse
+}
+
+// ForecastPoint är SMHI:s väderprognos för en tidpunkt
+type ForecastPoint struct {
+	Time            time.Time `json:"time"`
+	Temperature     float64   `json:"temperature"`
+	CloudCover      *float64  `json:"cloud_cover,omitempty"`      // Oktas (0-8)
+	GlobalRadiation *float64  `json:"global_radiation,omitempty"` // W/m²
+}
+
+// ForecastStatus beskriver hur färsk väderprognosen är
+type ForecastStatus struct {
+	Source       string    `json:"source"` // smhi, cache (senaste hämtning misslyckades) eller none
+	Lat          float64   `json:"lat"`
+	Lon          float64   `json:"lon"`
+	ApprovedTime time.Time `json:"approved_time,omitempty"` // SMHI:s utgivningstid
+	FetchedAt    time.Time `json:"fetched_at,omitempty"`    // Senaste lyckade hämtning
+	Stale        bool      `json:"stale"`
+	LastAttempt  time.Time `json:"last_attempt,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// IssuedPowerEstimate är en sparad prognos för ett kvart och när den utfärdades
+type IssuedPowerEstimate struct {
+	IssuedAt time.Time `json:"issued_at"`
+	PowerEstimate
+}
+
+// ForecastAccuracy är förbrukningsprognosens fel för en prognoshorisont
+type ForecastAccuracy struct {
+	Horizon  string  `json:"horizon"`   // T.ex. "1-3h"
+	MinHours float64 `json:"min_hours"` // Horisont från och med
+	MaxHours float64 `json:"max_hours"` // Horisont till
+	Samples  int     `json:"samples"`
+	MAEKW    float64 `json:"mae_kw"`
+	BiasKW   float64 `json:"bias_kw"` // Prognos minus utfall, positivt = prognosen för hög
+	RMSEKW   float64 `json:"rmse_kw"`
+}
+
+// SimulationPoint är simulerad batterinivå för ett kvart
+type SimulationPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Mode      int       `json:"mode"`
+	SoC       float64   `json:"soc"`      // Laddnivå i % vid kvartens början
+	PowerKW   float64   `json:"power_kw"` // Prognosticerad förbrukning
+	SolarKW   float64   `json:"solar_kw"` // Prognosticerad solelsproduktion
+	GridKWh   float64   `json:"grid_kwh"` // Energi som köps från nätet under kvarten
+}
+
+// HourlyPower är uppmätt medeleffekt från elnätet under en timme
+type HourlyPower struct {
+	Hour       time.Time `json:"hour"`
+	AvgKW      float64   `json:"avg_kw"`
+	WeightedKW float64   `json:"weighted_kw"` // Efter nätägarens viktning (t.ex. halv vikt nattetid)
+	Samples    int       `json:"samples"`
+}
+
+// PeakSummary är månadens effekttoppar enligt nätägarens regler
+type PeakSummary struct {
+	Month       string        `json:"month"` // YYYY-MM
+	Peaks       []HourlyPower `json:"peaks"`
+	AverageKW   float64       `json:"average_kw"`   // Medel av topparna, det som debiteras
+	ThresholdKW float64       `json:"threshold_kw"` // Viktad timeffekt över detta ger en ny topp
+}
+
+// HistoryEntry är en rad i history: ett kvartsvärde (event "sample") eller ett lägesbyte
+type HistoryEntry struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Mode       int       `json:"mode"`
+	BatterySoC *float64  `json:"battery_soc,omitempty"`
+	PowerKW    *float64  `json:"power_kw,omitempty"`
+	PriceOre   *int      `json:"price_ore,omitempty"`
+	Event      string    `json:"event"`
+	Detail     string    `json:"detail,omitempty"`
 }
 
 // CurrentModeResponse är vad vi returnerar till Home Assistant
 type CurrentModeResponse struct {
-	Mode        int       `json:"mode"`
-	Timestamp   time.Time `json:"timestamp"`
-	NextChange  time.Time `json:"next_change,omitempty"`
-	NextMode    int       `json:"next_mode,omitempty"`
-	Description string    `json:"description"`
+	Mode        int           `json:"mode"`
+	Timestamp   time.Time     `json:"timestamp"`
+	NextChange  time.Time     `json:"next_change,omitempty"`
+	NextMode    int           `json:"next_mode,omitempty"`
+	Description string        `json:"description"`
+	Override    *ModeOverride `json:"override,omitempty"` // Satt när ett manuellt läge gäller istället för schemat
+}
+
+// ModeOverride är ett tillfälligt manuellt läge som gäller före schemat tills det löper ut
+type ModeOverride struct {
+	Mode      int       `json:"mode"`
+	Start     time.Time `json:"start"`
+	Expires   time.Time `json:"expires"`
+	Reason    string    `json:"reason,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Event är en händelse som skickas till klienter på /api/events
+type Event struct {
+	Type string      `json:"type"` // mode, soc, prices, schedule eller override
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
 }
 
 // BatterySoCResponse är aktuellt laddtillstånd
@@ -54,3 +259,41 @@ var ModeDescriptions = map[int]string{
 	5: "Laddbox Garage aktiv",
 	6: "Laddbox Ute aktiv",
 }
+
+// Roller, i stigande behörighet
+const (
+	RoleViewer  = "viewer"  // Läsa allt utom inställningar
+	RolePlanner = "planner" // Även ändra schema, manuellt läge och mallar
+	RoleAdmin   = "admin"   // Även inställningar, tariff och användare
+)
+
+// RoleLevels rangordnar rollerna. En roll har alla lägre rollers behörighet.
+var RoleLevels = map[string]int{
+	RoleViewer:  1,
+	RolePlanner: 2,
+	RoleAdmin:   3,
+}
+
+// User är en användare som loggar in i webbgränssnittet
+type User struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIToken är en nyckel för automatiseringar, t.ex. Home Assistant. Själva nyckeln sparas bara som hash.
+type APIToken struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Role      string     `json:"role"`
+	CreatedAt time.Time  `json:"created_at"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+}
+
+// Principal är den som gjort en förfrågan: en inloggad användare eller en API-nyckel
+type Principal struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	Kind string `json:"kind"` // user eller token
+}
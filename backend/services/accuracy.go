@@ -0,0 +1,80 @@
+package services
+
+import (
+	"fmt"
+	"math"
+
+	"battery-scheduler/models"
+)
+
+// ForecastHorizons är gränserna (timmar) för prognoshorisonterna i träffsäkerhetsstatistiken
+var ForecastHorizons = []float64{0, 1, 3, 6, 12, 24, 48}
+
+// MeasuredNetLoad plockar ut uppmätt nettolast per kvart (Unix-tid) ur history.
+// Bara passiva kvartar används, eftersom nätimporten annars även innehåller batteriets laddning och urladdning.
+func MeasuredNetLoad(history []models.HistoryEntry) map[int64]float64 {
+	measured := make(map[int64]float64)
+	for _, e := range history {
+		if e.Event != "sample" || e.Mode != modePassive || e.PowerKW == nil {
+			continue
+		}
+		measured[e.Timestamp.Unix()] = *e.PowerKW
+	}
+	return measured
+}
+
+// CalculateForecastAccuracy jämför sparade prognoser med uppmätt nettolast och räknar
+// MAE, bias och RMSE per horisont (tid från utfärdande till kvartens början) samt totalt
+func CalculateForecastAccuracy(estimates []models.IssuedPowerEstimate, measured map[int64]float64) ([]models.ForecastAccuracy, models.ForecastAccuracy) {
+	n := len(ForecastHorizons) - 1
+	sumAbs := make([]float64, n+1)
+	sumErr := make([]float64, n+1)
+	sumSq := make([]float64, n+1)
+	counts := make([]int, n+1)
+
+	for _, e := range estimates {
+		actual, ok := measured[e.Timestamp.Unix()]
+		if !ok {
+			continue
+		}
+		horizon := e.Timestamp.Sub(e.IssuedAt).Hours()
+		bucket := -1
+		for i := 0; i < n; i++ {
+			if horizon >= ForecastHorizons[i] && horizon < ForecastHorizons[i+1] {
+				bucket = i
+				break
+			}
+		}
+		if bucket < 0 {
+			continue
+		}
+
+		diff := e.NetKW - actual
+		for _, i := range []int{bucket, n} {
+			sumAbs[i] += math.Abs(diff)
+			sumErr[i] += diff
+			sumSq[i] += diff * diff
+			counts[i]++
+		}
+	}
+
+	result := func(i int, label string, minHours, maxHours float64) models.ForecastAccuracy {
+		a := models.ForecastAccuracy{Horizon: label, MinHours: minHours, MaxHours: maxHours, Samples: counts[i]}
+		if counts[i] > 0 {
+			c := float64(counts[i])
+			a.MAEKW = math.Round(sumAbs[i]/c*1000) / 1000
+			a.BiasKW = math.Round(sumErr[i]/c*1000) / 1000
+			a.RMSEKW = math.Round(math.Sqrt(sumSq[i]/c)*1000) / 1000
+		}
+		return a
+	}
+
+	horizons := make([]models.ForecastAccuracy, n)
+	for i := 0; i < n; i++ {
+		label := fmt.Sprintf("%g-%gh", ForecastHorizons[i], ForecastHorizons[i+1])
+		horizons[i] = result(i, label, ForecastHorizons[i], ForecastHorizons[i+1])
+	}
+	overall := result(n, "all", ForecastHorizons[0], ForecastHorizons[n])
+
+	return horizons, overall
+}
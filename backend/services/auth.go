@@ -0,0 +1,230 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"battery-scheduler/db"
+	"battery-scheduler/models"
+)
+
+// sessionDuration är hur länge en inloggning i webbgränssnittet gäller
+const sessionDuration = 30 * 24 * time.Hour
+
+// minPasswordLength är kortaste tillåtna lösenord
+const minPasswordLength = 8
+
+// ErrInvalidCredentials returneras när användarnamn, lösenord eller nyckel inte stämmer
+var ErrInvalidCredentials = errors.New("fel användarnamn eller lösenord")
+
+// dummyHash jämförs mot när användaren inte finns, så att svarstiden inte avslöjar vilka användare som finns
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("battery-scheduler"), bcrypt.DefaultCost)
+
+// AuthService hanterar användare, inloggningar och API-nycklar. Autentisering är avstängd
+// tills det finns minst en admin, så befintliga installationer fungerar som förut.
+type AuthService struct {
+	db     *db.Database
+	public map[string]bool // GET-sökvägar som alltid är öppna, t.ex. /api/current-mode
+
+	mu           sync.Mutex
+	enabled      bool
+	enabledKnown bool // enabled är räknat; nollställs när användare ändras
+}
+
+// NewAuthService skapar en AuthService. publicPaths är sökvägar som får läsas utan inloggning.
+func NewAuthService(database *db.Database, publicPaths []string) *AuthService {
+	public := make(map[string]bool)
+	for _, path := range publicPaths {
+		if path = strings.TrimSpace(path); path != "" {
+			public[path] = true
+		}
+	}
+	return &AuthService{db: database, public: public}
+}
+
+// Enabled returnerar true om autentisering krävs, dvs. om det finns någon admin.
+// Svaret sparas tills användarna ändras via SaveUser eller DeleteUser.
+func (s *AuthService) Enabled() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.enabledKnown {
+		return s.enabled, nil
+	}
+
+	n, err := s.db.CountAdmins()
+	if err != nil {
+		return false, err
+	}
+	s.enabled, s.enabledKnown = n > 0, true
+	return s.enabled, nil
+}
+
+// SaveUser lägger till eller uppdaterar en användare (se db.SaveUser)
+func (s *AuthService) SaveUser(u models.User, passwordHash string) (int, error) {
+	defer s.invalidateEnabled()
+	return s.db.SaveUser(u, passwordHash)
+}
+
+// DeleteUser tar bort en användare och loggar ut den
+func (s *AuthService) DeleteUser(id int) error {
+	defer s.invalidateEnabled()
+	return s.db.DeleteUser(id)
+}
+
+// invalidateEnabled gör att Enabled räknar admins på nytt
+func (s *AuthService) invalidateEnabled() {
+	s.mu.Lock()
+	s.enabledKnown = false
+	s.mu.Unlock()
+}
+
+// IsPublic returnerar true om sökvägen får läsas utan inloggning
+func (s *AuthService) IsPublic(path string) bool {
+	return s.public[path]
+}
+
+// ValidateRole kontrollerar att en roll finns
+func ValidateRole(role string) error {
+	if _, ok := models.RoleLevels[role]; !ok {
+		return fmt.Errorf("ogiltig roll %q (viewer, planner eller admin)", role)
+	}
+	return nil
+}
+
+// HasRole returnerar true om p har minst rollen role
+func HasRole(p *models.Principal, role string) bool {
+	return p != nil && models.RoleLevels[p.Role] >= models.RoleLevels[role]
+}
+
+// HashPassword kontrollerar och hashar ett lösenord
+func HashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("lösenordet måste vara minst %d tecken", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// EnsureAdmin skapar en admin med givet lösenord om användaren inte redan finns (används vid start)
+func (s *AuthService) EnsureAdmin(username, password string) error {
+	_, _, err := s.db.GetUserCredentials(username)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if _, err := s.SaveUser(models.User{Username: username, Role: models.RoleAdmin, CreatedAt: time.Now()}, hash); err != nil {
+		return err
+	}
+	log.Printf("Created admin user %s", username)
+	return nil
+}
+
+// Login kontrollerar användarnamn och lösenord och skapar en inloggning.
+// Returnerar nyckeln som klienten ska skicka tillbaka och när den går ut.
+func (s *AuthService) Login(username, password string) (string, models.User, time.Time, error) {
+	user, hash, err := s.db.GetUserCredentials(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", user, time.Time{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", user, time.Time{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return "", user, time.Time{}, ErrInvalidCredentials
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", user, time.Time{}, err
+	}
+	expires := time.Now().Add(sessionDuration)
+	if err := s.db.CreateSession(hashToken(token), user.ID, expires); err != nil {
+		return "", user, time.Time{}, err
+	}
+	return token, user, expires, nil
+}
+
+// Logout avslutar en inloggning
+func (s *AuthService) Logout(token string) error {
+	return s.db.DeleteSession(hashToken(token))
+}
+
+// Authenticate slår upp vem en nyckel tillhör: en API-nyckel eller en inloggning.
+// Returnerar ErrInvalidCredentials om nyckeln är okänd eller har gått ut.
+func (s *AuthService) Authenticate(token string) (*models.Principal, error) {
+	hash := hashToken(token)
+
+	apiToken, err := s.db.GetAPITokenByHash(hash)
+	if err == nil {
+		if err := s.db.TouchAPIToken(apiToken.ID, time.Now()); err != nil {
+			log.Printf("Failed to update last use of API token %d: %v", apiToken.ID, err)
+		}
+		return &models.Principal{Name: apiToken.Name, Role: apiToken.Role, Kind: "token"}, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	user, expires, err := s.db.GetSessionUser(hash)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && time.Now().After(expires)) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &models.Principal{Name: user.Username, Role: user.Role, Kind: "user"}, nil
+}
+
+// CreateAPIToken skapar en API-nyckel. Nyckeln returneras bara här och kan inte hämtas igen.
+func (s *AuthService) CreateAPIToken(name, role string) (string, models.APIToken, error) {
+	t := models.APIToken{Name: name, Role: role, CreatedAt: time.Now()}
+	if strings.TrimSpace(name) == "" {
+		return "", t, fmt.Errorf("namn saknas")
+	}
+	if err := ValidateRole(role); err != nil {
+		return "", t, err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", t, err
+	}
+	if t.ID, err = s.db.CreateAPIToken(t, hashToken(token)); err != nil {
+		return "", t, err
+	}
+	return token, t, nil
+}
+
+// newToken skapar en slumpmässig nyckel
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken är det som sparas i databasen. Nycklarna är slumpmässiga nog för att sha256 räcker.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
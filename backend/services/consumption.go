@@ -0,0 +1,324 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"battery-scheduler/db"
+	"battery-scheduler/models"
+)
+
+// DefaultBalanceTempC är temperaturen under vilken värmepumpen antas gå (samma som ConsumptionFromTemperature)
+const DefaultBalanceTempC = 15.0
+
+// minConsumptionSamples är minsta antal kvartar (tre dygn) som krävs för att anpassa en modell
+const minConsumptionSamples = 3 * 96
+
+// ErrInsufficientConsumptionData returneras när det finns för lite uppmätt last att anpassa mot
+var ErrInsufficientConsumptionData = errors.New("för lite förbrukningsdata")
+
+// Modellens parametrar: 24 timmar, 6 veckodagar (måndag är referens) och två värmetermer
+const (
+	featureWeekday   = 24
+	featureHeating   = featureWeekday + 6
+	consumptionTerms = featureHeating + 2
+)
+
+// ConsumptionSample är uppmätt medellast och utetemperatur för ett kvart
+type ConsumptionSample struct {
+	Time    time.Time
+	PowerKW float64
+	TempC   float64
+}
+
+// ConsumptionModelService anpassar och håller den förbrukningsmodell som prognoserna använder.
+// Utan anpassad modell används den fasta kurvan i ConsumptionFromTemperature.
+type ConsumptionModelService struct {
+	db                *db.Database
+	ha                *HomeAssistantService
+	loadEntity        string // Husets last i Home Assistant, tomt = kvartsvärden i history
+	temperatureEntity string // Utetemperatur i Home Assistant
+
+	mu    sync.RWMutex
+	model *models.ConsumptionModel
+}
+
+// NewConsumptionModelService skapar tjänsten och läser in senast sparade modell
+func NewConsumptionModelService(database *db.Database, ha *HomeAssistantService, loadEntity, temperatureEntity string) *ConsumptionModelService {
+	model, err := database.GetConsumptionModel()
+	if err != nil {
+		log.Printf("Failed to load consumption model, using default curve: %v", err)
+	}
+	return &ConsumptionModelService{
+		db:                database,
+		ha:                ha,
+		loadEntity:        loadEntity,
+		temperatureEntity: temperatureEntity,
+		model:             model,
+	}
+}
+
+// Model returnerar aktiv anpassad modell, eller nil om den fasta kurvan används
+func (c *ConsumptionModelService) Model() *models.ConsumptionModel {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.model
+}
+
+// Estimate returnerar prognosticerad förbrukning (kW) för kvarten t vid utetemperaturen tempC
+func (c *ConsumptionModelService) Estimate(t time.Time, tempC float64) float64 {
+	if model := c.Model(); model != nil {
+		return PredictConsumption(*model, t, tempC)
+	}
+	return ConsumptionFromTemperature(tempC)
+}
+
+// Fit anpassar en ny modell mot uppmätt last mellan from och to, sparar den och börjar använda den
+func (c *ConsumptionModelService) Fit(from, to time.Time) (*models.ConsumptionModel, error) {
+	samples, source, err := c.samples(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	model, err := FitConsumptionModel(samples, DefaultBalanceTempC)
+	if err != nil {
+		return nil, err
+	}
+	model.FittedAt = time.Now()
+	model.From = from
+	model.To = to
+	model.Source = source
+
+	if err := c.db.SaveConsumptionModel(*model); err != nil {
+		return nil, fmt.Errorf("failed to save consumption model: %w", err)
+	}
+
+	c.mu.Lock()
+	c.model = model
+	c.mu.Unlock()
+
+	return model, nil
+}
+
+// samples hämtar kvartsvis last och temperatur. Lasten tas från loadEntity om den är satt,
+// annars från history där bara passiva kvartar används (batteriet påverkar inte nätimporten då).
+func (c *ConsumptionModelService) samples(from, to time.Time) ([]ConsumptionSample, string, error) {
+	if !c.ha.Configured() || c.temperatureEntity == "" {
+		return nil, "", fmt.Errorf("utetemperatur saknas: ange Home Assistant och ha_temperature_entity")
+	}
+
+	temperatures, err := c.ha.GetHistory(c.temperatureEntity, from, to)
+	if err != nil {
+		return nil, "", err
+	}
+	tempByQuarter := quarterAverages(temperatures, from, to)
+
+	var source string
+	loadByQuarter := make(map[int64]float64)
+	if c.loadEntity != "" {
+		source = "home_assistant"
+		loads, err := c.ha.GetHistory(c.loadEntity, from, to)
+		if err != nil {
+			return nil, "", err
+		}
+		loadByQuarter = quarterAverages(loads, from, to)
+	} else {
+		source = "history"
+		history, err := c.db.GetHistory(from, to)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, e := range history {
+			if e.Event != "sample" || e.Mode != 1 || e.PowerKW == nil || *e.PowerKW < 0 {
+				continue
+			}
+			loadByQuarter[e.Timestamp.Unix()] = *e.PowerKW
+		}
+	}
+
+	var samples []ConsumptionSample
+	for t := from.Truncate(15 * time.Minute); t.Before(to); t = t.Add(15 * time.Minute) {
+		load, okLoad := loadByQuarter[t.Unix()]
+		temp, okTemp := tempByQuarter[t.Unix()]
+		if okLoad && okTemp {
+			samples = append(samples, ConsumptionSample{Time: t, PowerKW: load, TempC: temp})
+		}
+	}
+
+	return samples, source, nil
+}
+
+// quarterAverages räknar tidsviktat medelvärde per kvart (Unix-tid för kvartens start).
+// Home Assistant sparar bara ändringar, så varje värde gäller fram till nästa.
+// Kvartar med värde under mindre än halva kvarten utelämnas.
+func quarterAverages(points []HAHistoryPoint, from, to time.Time) map[int64]float64 {
+	sums := make(map[int64]float64)
+	covered := make(map[int64]time.Duration)
+
+	for i, p := range points {
+		start := p.Time
+		if start.Before(from) {
+			start = from
+		}
+		end := to
+		if i+1 < len(points) && points[i+1].Time.Before(to) {
+			end = points[i+1].Time
+		}
+
+		for start.Before(end) {
+			quarter := start.Truncate(15 * time.Minute)
+			segmentEnd := quarter.Add(15 * time.Minute)
+			if end.Before(segmentEnd) {
+				segmentEnd = end
+			}
+			d := segmentEnd.Sub(start)
+			sums[quarter.Unix()] += p.Value * d.Hours()
+			covered[quarter.Unix()] += d
+			start = segmentEnd
+		}
+	}
+
+	averages := make(map[int64]float64)
+	for quarter, d := range covered {
+		if d >= 15*time.Minute/2 {
+			averages[quarter] = sums[quarter] / d.Hours()
+		}
+	}
+	return averages
+}
+
+// FitConsumptionModel anpassar modellen med minsta kvadrat-metoden. Alla timmar på dygnet
+// måste finnas med i underlaget. Veckodagar som saknas får tillägget 0.
+func FitConsumptionModel(samples []ConsumptionSample, balanceTempC float64) (*models.ConsumptionModel, error) {
+	if len(samples) < minConsumptionSamples {
+		return nil, fmt.Errorf("%w: %d kvartar, minst %d krävs", ErrInsufficientConsumptionData, len(samples), minConsumptionSamples)
+	}
+
+	var hours [24]int
+	for _, s := range samples {
+		hours[s.Time.In(time.Local).Hour()]++
+	}
+	for h, n := range hours {
+		if n == 0 {
+			return nil, fmt.Errorf("%w: inga kvartar för timme %d", ErrInsufficientConsumptionData, h)
+		}
+	}
+
+	// Normalekvationer XᵀX·β = Xᵀy, med liten regularisering så att saknade veckodagar inte ger singulär matris
+	xtx := make([][]float64, consumptionTerms)
+	for i := range xtx {
+		xtx[i] = make([]float64, consumptionTerms)
+		xtx[i][i] = 1e-6
+	}
+	xty := make([]float64, consumptionTerms)
+
+	for _, s := range samples {
+		x := consumptionFeatures(s.Time, s.TempC, balanceTempC)
+		for i := range x {
+			if x[i] == 0 {
+				continue
+			}
+			xty[i] += x[i] * s.PowerKW
+			for j := range x {
+				xtx[i][j] += x[i] * x[j]
+			}
+		}
+	}
+
+	beta, err := solveLinear(xtx, xty)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fit consumption model: %w", err)
+	}
+
+	model := &models.ConsumptionModel{
+		Samples:          len(samples),
+		BalanceTempC:     balanceTempC,
+		HeatingLinear:    beta[featureHeating],
+		HeatingQuadratic: beta[featureHeating+1],
+	}
+	copy(model.HourKW[:], beta[:featureWeekday])
+	copy(model.WeekdayKW[1:], beta[featureWeekday:featureHeating])
+
+	var sumSq, sumAbs, defaultSumSq float64
+	for _, s := range samples {
+		residual := PredictConsumption(*model, s.Time, s.TempC) - s.PowerKW
+		sumSq += residual * residual
+		sumAbs += math.Abs(residual)
+
+		defaultResidual := ConsumptionFromTemperature(s.TempC) - s.PowerKW
+		defaultSumSq += defaultResidual * defaultResidual
+	}
+	n := float64(len(samples))
+	model.RMSEKW = math.Sqrt(sumSq / n)
+	model.MAEKW = sumAbs / n
+	model.DefaultRMSEKW = math.Sqrt(defaultSumSq / n)
+
+	return model, nil
+}
+
+// PredictConsumption beräknar förbrukningen (kW) enligt en anpassad modell
+func PredictConsumption(m models.ConsumptionModel, t time.Time, tempC float64) float64 {
+	local := t.In(time.Local)
+	g := math.Max(0, m.BalanceTempC-tempC)
+	power := m.HourKW[local.Hour()] + m.WeekdayKW[weekdayIndex(local)] + m.HeatingLinear*g + m.HeatingQuadratic*g*g
+	return math.Max(0, power)
+}
+
+// consumptionFeatures är modellens förklarande variabler för en kvart
+func consumptionFeatures(t time.Time, tempC, balanceTempC float64) []float64 {
+	local := t.In(time.Local)
+	x := make([]float64, consumptionTerms)
+	x[local.Hour()] = 1
+	if wd := weekdayIndex(local); wd > 0 {
+		x[featureWeekday+wd-1] = 1
+	}
+	g := math.Max(0, balanceTempC-tempC)
+	x[featureHeating] = g
+	x[featureHeating+1] = g * g
+	return x
+}
+
+// weekdayIndex returnerar 0 för måndag ... 6 för söndag
+func weekdayIndex(t time.Time) int {
+	return (int(t.Weekday()) + 6) % 7
+}
+
+// solveLinear löser a·x = b med Gausselimination och partiell pivotering. a och b skrivs över.
+func solveLinear(a [][]float64, b []float64) ([]float64, error) {
+	n := len(b)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil, fmt.Errorf("singular matrix")
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+
+		for row := col + 1; row < n; row++ {
+			factor := a[row][col] / a[col][col]
+			for k := col; k < n; k++ {
+				a[row][k] -= factor * a[col][k]
+			}
+			b[row] -= factor * b[col]
+		}
+	}
+
+	x := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := b[row]
+		for k := row + 1; k < n; k++ {
+			sum -= a[row][k] * x[k]
+		}
+		x[row] = sum / a[row][row]
+	}
+	return x, nil
+}
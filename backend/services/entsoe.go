@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"sort"
+	"strings"
 	"time"
 
 	"battery-scheduler/models"
@@ -14,6 +15,7 @@ import (
 type EntsoeService struct {
 	token string
 	area  string // SE1, SE2, SE3, SE4
+	rates ExchangeRateSource
 }
 
 // XML-strukturer för Entsoe API-svar
@@ -41,11 +43,17 @@ type Point struct {
 	Price    float64 `xml:"price.amount"`
 }
 
-// NewEntsoeService skapar en ny Entsoe-service
-func NewEntsoeService(token, area string) *EntsoeService {
+// Name returnerar providerns namn (PriceProvider)
+func (e *EntsoeService) Name() string {
+	return "entsoe"
+}
+
+// NewEntsoeService skapar en ny Entsoe-service. rates kan vara nil, då används DefaultEURSEK.
+func NewEntsoeService(token, area string, rates ExchangeRateSource) *EntsoeService {
 	return &EntsoeService{
 		token: token,
 		area:  area,
+		rates: rates,
 	}
 }
 
@@ -87,7 +95,8 @@ func (e *EntsoeService) FetchPrices(from, to time.Time) ([]models.Price, error)
 	// Hämta data
 	resp, err := http.Get(url)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch from Entsoe: %w", err)
+		// Felet innehåller URL:en och därmed token, som inte får hamna i loggen
+		return nil, fmt.Errorf("failed to fetch from Entsoe: %s", strings.ReplaceAll(err.Error(), e.token, "***"))
 	}
 	defer resp.Body.Close()
 
@@ -109,6 +118,7 @@ func (e *EntsoeService) FetchPrices(from, to time.Time) ([]models.Price, error)
 
 	// Konvertera till vårt format
 	var prices []models.Price
+	dayRates := make(map[string]float64)
 
 	for _, ts := range entsoeResp.TimeSeries {
 		startTime, err := time.Parse("2006-01-02T15:04Z", ts.Period.TimeInterval.Start)
@@ -120,37 +130,34 @@ func (e *EntsoeService) FetchPrices(from, to time.Time) ([]models.Price, error)
 			// Position är 1-baserat (1-96 för kvartar)
 			timestamp := startTime.Add(time.Duration(point.Position-1) * 15 * time.Minute)
 
-			// Konvertera från EUR/MWh till öre/kWh inkl 25% moms
-			// 1 EUR/MWh = 0.1 öre/kWh (ungefär, beroende på växelkurs)
-			// Vi använder ca 11 SEK/EUR som standard
-			// Korrekt konvertering: EUR/MWh -> öre/kWh inkl moms
-			// 1. EUR/MWh -> EUR/kWh: /1000
-			// 2. EUR -> SEK: *11 (växelkurs)
-			// 3. SEK -> öre: *100
-			// 4. Lägg till moms: *1.25
-			// EUR/MWh -> öre/kWh inkl moms
-			// Exempel: 14.18 EUR/MWh -> 0.01418 EUR/kWh -> 0.164 SEK/kWh -> 16.4 öre/kWh -> 20.5 öre/kWh
-			const EXCHANGE_RATE = 11.6 // SEK per EUR (uppdatera efter behov)
-			const VAT = 1.25           // 25% moms
-			priceOreInclMoms := int(point.Price / 1000.0 * EXCHANGE_RATE * 100.0 * VAT)
+			// Konvertera från EUR/MWh till öre/kWh inkl moms med leveransdagens växelkurs
+			day := timestamp.In(time.Local).Format("2006-01-02")
+			rate, ok := dayRates[day]
+			if !ok {
+				rate = rateForDay(e.rates, timestamp)
+				dayRates[day] = rate
+			}
+			eurPerMWh := point.Price
 
 			prices = append(prices, models.Price{
-				Timestamp: timestamp.In(time.Local), // Konvertera till lokal tid
-				PriceOre:  priceOreInclMoms,
-				Area:      e.area,
+				Timestamp:   timestamp.In(time.Local), // Konvertera till lokal tid
+				PriceOre:    eurMWhToOreInclVAT(eurPerMWh, rate),
+				PriceEurMWh: &eurPerMWh,
+				Area:        e.area,
+				Source:      e.Name(),
 			})
 		}
 	}
 
 	// Sort prices by timestamp and fill any gaps with interpolated values
-	prices = e.fillPriceGaps(prices, from, to)
+	prices = fillPriceGaps(prices, from, to, e.area, e.Name())
 
 	return prices, nil
 }
 
 // fillPriceGaps sorts prices by timestamp and fills any missing 15-minute
-// periods with interpolated values
-func (e *EntsoeService) fillPriceGaps(prices []models.Price, from, to time.Time) []models.Price {
+// periods with interpolated values. Shared by all price providers.
+func fillPriceGaps(prices []models.Price, from, to time.Time, area, source string) []models.Price {
 	if len(prices) == 0 {
 		return prices
 	}
@@ -191,11 +198,13 @@ func (e *EntsoeService) fillPriceGaps(prices []models.Price, from, to time.Time)
 			result = append(result, existing)
 		} else {
 			// Gap detected - interpolate from neighbors
-			interpolated := e.interpolatePrice(current, priceMap)
+			priceOre, eurMWh := interpolatePrice(current, priceMap)
 			result = append(result, models.Price{
-				Timestamp: current,
-				PriceOre:  interpolated,
-				Area:      e.area,
+				Timestamp:   current,
+				PriceOre:    priceOre,
+				Area:        area,
+				Source:      source,
+				PriceEurMWh: eurMWh,
 			})
 		}
 	}
@@ -204,17 +213,17 @@ func (e *EntsoeService) fillPriceGaps(prices []models.Price, from, to time.Time)
 }
 
 // interpolatePrice finds the nearest prices before and after the gap
-// and returns an interpolated value
-func (e *EntsoeService) interpolatePrice(t time.Time, priceMap map[int64]models.Price) int {
+// and returns an interpolated value in öre, and in EUR/MWh when the
+// neighbors have raw prices (nil otherwise)
+func interpolatePrice(t time.Time, priceMap map[int64]models.Price) (int, *float64) {
 	// Look for nearest price before
-	var beforePrice, afterPrice int
+	var before, after models.Price
 	var foundBefore, foundAfter bool
 
 	// Search backwards up to 4 hours (16 quarters)
 	for delta := 15 * time.Minute; delta <= 4*time.Hour; delta += 15 * time.Minute {
-		before := t.Add(-delta)
-		if p, ok := priceMap[before.Unix()]; ok {
-			beforePrice = p.PriceOre
+		if p, ok := priceMap[t.Add(-delta).Unix()]; ok {
+			before = p
 			foundBefore = true
 			break
 		}
@@ -222,9 +231,8 @@ func (e *EntsoeService) interpolatePrice(t time.Time, priceMap map[int64]models.
 
 	// Search forwards up to 4 hours (16 quarters)
 	for delta := 15 * time.Minute; delta <= 4*time.Hour; delta += 15 * time.Minute {
-		after := t.Add(delta)
-		if p, ok := priceMap[after.Unix()]; ok {
-			afterPrice = p.PriceOre
+		if p, ok := priceMap[t.Add(delta).Unix()]; ok {
+			after = p
 			foundAfter = true
 			break
 		}
@@ -232,15 +240,20 @@ func (e *EntsoeService) interpolatePrice(t time.Time, priceMap map[int64]models.
 
 	// Interpolate based on what we found
 	if foundBefore && foundAfter {
-		return (beforePrice + afterPrice) / 2
+		var eurMWh *float64
+		if before.PriceEurMWh != nil && after.PriceEurMWh != nil {
+			avg := (*before.PriceEurMWh + *after.PriceEurMWh) / 2
+			eurMWh = &avg
+		}
+		return (before.PriceOre + after.PriceOre) / 2, eurMWh
 	} else if foundBefore {
-		return beforePrice
+		return before.PriceOre, before.PriceEurMWh
 	} else if foundAfter {
-		return afterPrice
+		return after.PriceOre, after.PriceEurMWh
 	}
 
 	// No neighbors found - use a default value
-	return 100 // 100 öre as fallback
+	return 100, nil // 100 öre as fallback
 }
 
 // GenerateMockPrices skapar mock-data för testning (används tills token finns)
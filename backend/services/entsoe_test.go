@@ -0,0 +1,47 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+func TestFillPriceGapsInterpolatesEurPrice(t *testing.T) {
+	start := time.Date(2025, 10, 6, 0, 0, 0, 0, time.Local)
+	eur := func(v float64) *float64 { return &v }
+	prices := []models.Price{
+		{Timestamp: start, PriceOre: 100, PriceEurMWh: eur(80)},
+		{Timestamp: start.Add(30 * time.Minute), PriceOre: 140, PriceEurMWh: eur(120)},
+		{Timestamp: start.Add(45 * time.Minute), PriceOre: 60},
+	}
+
+	filled := fillPriceGaps(prices, start, start.Add(75*time.Minute), "SE3", "test")
+	if len(filled) != 5 {
+		t.Fatalf("got %d prices, want 5", len(filled))
+	}
+
+	tests := []struct {
+		name   string
+		index  int
+		ore    int
+		eurMWh *float64
+	}{
+		{"between two raw prices", 1, 120, eur(100)},
+		{"after the last price", 4, 60, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filled[tt.index]
+			if p.PriceOre != tt.ore {
+				t.Errorf("price = %d öre, want %d", p.PriceOre, tt.ore)
+			}
+			switch {
+			case tt.eurMWh == nil && p.PriceEurMWh != nil:
+				t.Errorf("EUR/MWh = %v, want none", *p.PriceEurMWh)
+			case tt.eurMWh != nil && (p.PriceEurMWh == nil || *p.PriceEurMWh != *tt.eurMWh):
+				t.Errorf("EUR/MWh = %v, want %v", p.PriceEurMWh, *tt.eurMWh)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+// Händelsetyper på /api/events
+const (
+	EventMode     = "mode"     // Aktivt läge har bytts (models.CurrentModeResponse)
+	EventSoC      = "soc"      // Ny SoC-avläsning (models.BatterySoCResponse)
+	EventPrices   = "prices"   // Nya priser har sparats
+	EventSchedule = "schedule" // En ny schemaversion har sparats
+	EventOverride = "override" // Manuellt läge har satts eller tagits bort
+)
+
+// eventBuffer är hur många händelser en långsam klient kan ligga efter innan händelser tappas
+const eventBuffer = 32
+
+// socPollInterval är hur ofta SoC läses från Home Assistant när någon lyssnar
+const socPollInterval = 30 * time.Second
+
+// EventHub skickar händelser till alla anslutna klienter och bevakar själv lägesbyten
+// och SoC, så att klienter inte behöver polla /current-mode och /battery-soc
+type EventHub struct {
+	scheduler *SchedulerService
+	ha        *HomeAssistantService
+
+	mu          sync.Mutex
+	subscribers map[chan models.Event]struct{}
+	wake        chan struct{}
+}
+
+// NewEventHub skapar en händelsehub. Run måste startas för läges- och SoC-händelser.
+func NewEventHub(scheduler *SchedulerService, ha *HomeAssistantService) *EventHub {
+	return &EventHub{
+		scheduler:   scheduler,
+		ha:          ha,
+		subscribers: make(map[chan models.Event]struct{}),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Subscribe registrerar en klient. Den returnerade funktionen avregistrerar och måste anropas.
+func (h *EventHub) Subscribe() (<-chan models.Event, func()) {
+	ch := make(chan models.Event, eventBuffer)
+
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	h.notify()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subscribers, ch)
+		h.mu.Unlock()
+	}
+}
+
+// Publish skickar en händelse till alla klienter. Klienter som inte hinner med tappar händelsen.
+// Schema- och override-händelser väcker även lägesbevakningen.
+func (h *EventHub) Publish(eventType string, data interface{}) {
+	event := models.Event{Type: eventType, Time: time.Now(), Data: data}
+
+	h.mu.Lock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Event subscriber is falling behind, dropping %s event", eventType)
+		}
+	}
+	h.mu.Unlock()
+
+	if eventType == EventSchedule || eventType == EventOverride {
+		h.notify()
+	}
+}
+
+func (h *EventHub) subscriberCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+func (h *EventHub) notify() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run bevakar läge och SoC tills programmet avslutas. Läget kontrolleras vid varje brytpunkt
+// och när schemat ändras, SoC var 30:e sekund så länge någon klient är ansluten.
+func (h *EventHub) Run() {
+	var lastMode *models.CurrentModeResponse
+	var lastSoC *float64
+	var nextSoCPoll time.Time
+
+	for {
+		now := time.Now()
+		current := h.scheduler.GetCurrentMode(now)
+		if lastMode == nil || current.Mode != lastMode.Mode || (current.Override == nil) != (lastMode.Override == nil) {
+			if lastMode != nil {
+				h.Publish(EventMode, current)
+			}
+			lastMode = &current
+		}
+
+		listening := h.subscriberCount() > 0
+		if listening && h.ha.Configured() && !now.Before(nextSoCPoll) {
+			nextSoCPoll = now.Add(socPollInterval)
+			if soc, changed, err := h.ha.GetSoC(); err != nil {
+				log.Printf("Events: failed to read SoC: %v", err)
+			} else if lastSoC == nil || soc != *lastSoC {
+				lastSoC = &soc
+				h.Publish(EventSoC, models.BatterySoCResponse{Percentage: soc, Timestamp: changed})
+			}
+		}
+
+		wait := socPollInterval
+		if listening && h.ha.Configured() {
+			wait = time.Until(nextSoCPoll)
+		}
+		if !current.NextChange.IsZero() {
+			if until := time.Until(current.NextChange); until < wait {
+				wait = until
+			}
+		}
+		if wait < time.Second {
+			wait = time.Second
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-h.wake:
+		}
+	}
+}
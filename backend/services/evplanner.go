@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+// Laddboxlägen. Batteriet står passivt medan en laddbox är aktiv.
+const (
+	modeChargerGarage  = 5
+	modeChargerOutdoor = 6
+)
+
+// EVChargeRequest är en bil som ska laddas klart före avresa
+type EVChargeRequest struct {
+	Mode      int       `json:"mode"`       // 5 = Laddbox Garage, 6 = Laddbox Ute
+	Departure time.Time `json:"departure"`  // Laddningen ska vara klar hit
+	NeededKWh float64   `json:"needed_kwh"` // Energi som ska laddas in
+	PowerKW   float64   `json:"power_kw"`   // Laddeffekt, 0 = laddboxens inställda effekt
+}
+
+// EVChargePlan är planerad laddning för en bil
+type EVChargePlan struct {
+	EVChargeRequest
+	Quarters  []time.Time `json:"quarters"`
+	EnergyKWh float64     `json:"energy_kwh"`
+	CostOre   float64     `json:"cost_ore"`
+}
+
+// ValidateEVChargeRequest kontrollerar en laddbeställning
+func ValidateEVChargeRequest(r EVChargeRequest) error {
+	if r.Mode != modeChargerGarage && r.Mode != modeChargerOutdoor {
+		return fmt.Errorf("ogiltig laddbox %d (5 = Garage, 6 = Ute)", r.Mode)
+	}
+	if r.Departure.IsZero() {
+		return fmt.Errorf("avresetid saknas för laddbox %d", r.Mode)
+	}
+	if r.NeededKWh <= 0 {
+		return fmt.Errorf("needed_kwh måste vara större än 0 för laddbox %d", r.Mode)
+	}
+	if r.PowerKW <= 0 {
+		return fmt.Errorf("power_kw måste vara större än 0 för laddbox %d", r.Mode)
+	}
+	return nil
+}
+
+// PlanEVCharging lägger in laddboxlägen på de billigaste kvartarna före varje bils avresa.
+// modes är nuvarande läge per kvart i prices. Tidigare planerad laddning för laddboxarna som
+// planeras tas bort och ersätts med passivt läge. Kvartar med effektbegränsning eller där den
+// andra laddboxen redan är aktiv används inte, så laddboxarna överlappar aldrig.
+// Bilar planeras i avreseordning. Returnerar nya lägen per kvart och planen per bil.
+func PlanEVCharging(prices []models.Price, modes []int, requests []EVChargeRequest) ([]int, []EVChargePlan, error) {
+	if len(prices) != len(modes) {
+		return nil, nil, fmt.Errorf("priser och lägen har olika längd")
+	}
+
+	planned := make(map[int]bool)
+	for _, r := range requests {
+		if err := ValidateEVChargeRequest(r); err != nil {
+			return nil, nil, err
+		}
+		if planned[r.Mode] {
+			return nil, nil, fmt.Errorf("laddbox %d förekommer flera gånger", r.Mode)
+		}
+		planned[r.Mode] = true
+	}
+
+	result := make([]int, len(modes))
+	for i, mode := range modes {
+		if planned[mode] {
+			mode = modePassive
+		}
+		result[i] = mode
+	}
+
+	sorted := make([]EVChargeRequest, len(requests))
+	copy(sorted, requests)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Departure.Before(sorted[j].Departure)
+	})
+
+	var plans []EVChargePlan
+	for _, r := range sorted {
+		quarterKWh := r.PowerKW * 0.25
+		needed := int(math.Ceil(r.NeededKWh/quarterKWh - 1e-9))
+
+		var free []int
+		for i, p := range prices {
+			if p.Timestamp.Add(15 * time.Minute).After(r.Departure) {
+				break
+			}
+			if result[i] == modePeakLimit || result[i] == modeChargerGarage || result[i] == modeChargerOutdoor {
+				continue
+			}
+			free = append(free, i)
+		}
+		if len(free) < needed {
+			return nil, nil, fmt.Errorf("laddbox %d behöver %d kvartar före %s men bara %d är lediga",
+				r.Mode, needed, r.Departure.In(time.Local).Format("2006-01-02 15:04"), len(free))
+		}
+
+		sort.SliceStable(free, func(a, b int) bool {
+			return effectivePriceOre(prices[free[a]]) < effectivePriceOre(prices[free[b]])
+		})
+		chosen := free[:needed]
+
+		// Alla kvartar utom den dyraste är fulla, den dyraste laddar resten
+		plan := EVChargePlan{EVChargeRequest: r}
+		remaining := r.NeededKWh
+		for _, i := range chosen {
+			kwh := math.Min(quarterKWh, remaining)
+			remaining -= kwh
+			result[i] = r.Mode
+			plan.EnergyKWh += kwh
+			plan.CostOre += kwh * effectivePriceOre(prices[i])
+		}
+		sort.Ints(chosen)
+		for _, i := range chosen {
+			plan.Quarters = append(plan.Quarters, prices[i].Timestamp)
+		}
+		plan.EnergyKWh = math.Round(plan.EnergyKWh*1000) / 1000
+		plan.CostOre = math.Round(plan.CostOre)
+
+		plans = append(plans, plan)
+	}
+
+	return result, plans, nil
+}
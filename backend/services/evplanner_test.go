@@ -0,0 +1,143 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+var evStart = time.Date(2025, 10, 6, 0, 0, 0, 0, time.Local)
+
+func evPrices(ore ...int) []models.Price {
+	prices := make([]models.Price, len(ore))
+	for i, p := range ore {
+		prices[i] = models.Price{Timestamp: evStart.Add(time.Duration(i) * 15 * time.Minute), PriceOre: p}
+	}
+	return prices
+}
+
+func evQuarter(i int) time.Time {
+	return evStart.Add(time.Duration(i) * 15 * time.Minute)
+}
+
+func TestPlanEVCharging(t *testing.T) {
+	// 4 kW ger 1 kWh per kvart. Kvart 4 är billigast men ligger efter avresan.
+	prices := evPrices(50, 10, 40, 20, 5, 30)
+	garage := EVChargeRequest{Mode: modeChargerGarage, Departure: evQuarter(4), NeededKWh: 2, PowerKW: 4}
+
+	tests := []struct {
+		name     string
+		modes    []int
+		requests []EVChargeRequest
+		want     []int
+		quarters [][]time.Time
+	}{
+		{
+			name:     "cheapest quarters before departure",
+			modes:    []int{1, 1, 1, 1, 1, 1},
+			requests: []EVChargeRequest{garage},
+			want:     []int{1, 5, 1, 5, 1, 1},
+			quarters: [][]time.Time{{evQuarter(1), evQuarter(3)}},
+		},
+		{
+			name:     "peak limiting and the other charger are kept",
+			modes:    []int{1, 4, 1, 6, 1, 1},
+			requests: []EVChargeRequest{garage},
+			want:     []int{5, 4, 5, 6, 1, 1},
+			quarters: [][]time.Time{{evQuarter(0), evQuarter(2)}},
+		},
+		{
+			name:     "earlier plan for the same charger is replaced",
+			modes:    []int{5, 5, 1, 1, 1, 5},
+			requests: []EVChargeRequest{garage},
+			want:     []int{1, 5, 1, 5, 1, 1},
+			quarters: [][]time.Time{{evQuarter(1), evQuarter(3)}},
+		},
+		{
+			name:  "chargers never overlap, earliest departure first",
+			modes: []int{1, 1, 1, 1, 1, 1},
+			requests: []EVChargeRequest{
+				{Mode: modeChargerOutdoor, Departure: evQuarter(6), NeededKWh: 1.5, PowerKW: 4},
+				garage,
+			},
+			want:     []int{1, 5, 1, 5, 6, 6},
+			quarters: [][]time.Time{{evQuarter(1), evQuarter(3)}, {evQuarter(4), evQuarter(5)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modes, plans, err := PlanEVCharging(prices, tt.modes, tt.requests)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(modes, tt.want) {
+				t.Errorf("modes = %v, want %v", modes, tt.want)
+			}
+			if len(plans) != len(tt.quarters) {
+				t.Fatalf("got %d plans, want %d", len(plans), len(tt.quarters))
+			}
+			for i, plan := range plans {
+				if !reflect.DeepEqual(plan.Quarters, tt.quarters[i]) {
+					t.Errorf("plan %d quarters = %v, want %v", i, plan.Quarters, tt.quarters[i])
+				}
+				if plan.EnergyKWh != plan.NeededKWh {
+					t.Errorf("plan %d energy = %v kWh, want %v", i, plan.EnergyKWh, plan.NeededKWh)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanEVChargingCost(t *testing.T) {
+	// Den dyraste valda kvarten laddar bara resten: 1 kWh à 10 öre + 0,5 kWh à 20 öre
+	request := EVChargeRequest{Mode: modeChargerGarage, Departure: evQuarter(4), NeededKWh: 1.5, PowerKW: 4}
+	_, plans, err := PlanEVCharging(evPrices(50, 10, 40, 20), []int{1, 1, 1, 1}, []EVChargeRequest{request})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plans[0].CostOre != 20 {
+		t.Errorf("cost = %v öre, want 20", plans[0].CostOre)
+	}
+}
+
+func TestPlanEVChargingErrors(t *testing.T) {
+	prices := evPrices(50, 10, 40, 20)
+	tests := []struct {
+		name     string
+		modes    []int
+		requests []EVChargeRequest
+	}{
+		{
+			name:     "too few free quarters before departure",
+			modes:    []int{4, 4, 1, 1},
+			requests: []EVChargeRequest{{Mode: modeChargerGarage, Departure: evQuarter(4), NeededKWh: 3, PowerKW: 4}},
+		},
+		{
+			name:     "modes do not match prices",
+			modes:    []int{1, 1},
+			requests: []EVChargeRequest{{Mode: modeChargerGarage, Departure: evQuarter(4), NeededKWh: 1, PowerKW: 4}},
+		},
+		{
+			name:  "same charger twice",
+			modes: []int{1, 1, 1, 1},
+			requests: []EVChargeRequest{
+				{Mode: modeChargerGarage, Departure: evQuarter(2), NeededKWh: 1, PowerKW: 4},
+				{Mode: modeChargerGarage, Departure: evQuarter(4), NeededKWh: 1, PowerKW: 4},
+			},
+		},
+		{
+			name:     "not a charger mode",
+			modes:    []int{1, 1, 1, 1},
+			requests: []EVChargeRequest{{Mode: modeDischarge, Departure: evQuarter(4), NeededKWh: 1, PowerKW: 4}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := PlanEVCharging(prices, tt.modes, tt.requests); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
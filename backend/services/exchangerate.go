@@ -0,0 +1,158 @@
+package services
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"battery-scheduler/db"
+)
+
+// DefaultECBURL är ECB:s dagliga referenskurser (publiceras ca 16:00 CET på bankdagar)
+const DefaultECBURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+
+// DefaultEURSEK används om ingen kurs finns sparad (samma värde som tidigare var hårdkodat)
+const DefaultEURSEK = 11.6
+
+// ExchangeRateSource ger växelkursen SEK per EUR för en leveransdag
+type ExchangeRateSource interface {
+	RateFor(day time.Time) (float64, error)
+}
+
+// ExchangeRateService hämtar EUR/SEK från ECB och sparar kurserna per dag i databasen
+type ExchangeRateService struct {
+	url    string
+	db     *db.Database
+	client *http.Client
+
+	mu          sync.Mutex
+	lastFetched time.Time
+}
+
+// ECB-XML: <Cube><Cube time="2025-10-03"><Cube currency="SEK" rate="11.0"/></Cube></Cube>
+type ecbEnvelope struct {
+	Days []ecbDay `xml:"Cube>Cube"`
+}
+
+type ecbDay struct {
+	Time  string    `xml:"time,attr"`
+	Rates []ecbRate `xml:"Cube"`
+}
+
+type ecbRate struct {
+	Currency string `xml:"currency,attr"`
+	Rate     string `xml:"rate,attr"`
+}
+
+// NewExchangeRateService skapar en ny växelkurstjänst. Tom url ger DefaultECBURL.
+func NewExchangeRateService(url string, database *db.Database) *ExchangeRateService {
+	if url == "" {
+		url = DefaultECBURL
+	}
+	return &ExchangeRateService{
+		url:    url,
+		db:     database,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Refresh hämtar aktuella kurser från ECB och sparar SEK-kursen per dag
+func (e *ExchangeRateService) Refresh() error {
+	resp, err := e.client.Get(e.url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch ECB rates: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ECB returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read ECB response: %w", err)
+	}
+
+	var envelope ecbEnvelope
+	if err := xml.Unmarshal(body, &envelope); err != nil {
+		return fmt.Errorf("failed to parse ECB XML: %w", err)
+	}
+
+	rates := make(map[string]float64)
+	for _, day := range envelope.Days {
+		for _, r := range day.Rates {
+			if r.Currency != "SEK" {
+				continue
+			}
+			rate, err := strconv.ParseFloat(r.Rate, 64)
+			if err != nil {
+				return fmt.Errorf("invalid SEK rate %q for %s", r.Rate, day.Time)
+			}
+			rates[day.Time] = rate
+		}
+	}
+	if len(rates) == 0 {
+		return fmt.Errorf("ECB response contained no SEK rate")
+	}
+
+	if err := e.db.SaveExchangeRates("SEK", rates); err != nil {
+		return fmt.Errorf("failed to save exchange rates: %w", err)
+	}
+
+	e.mu.Lock()
+	e.lastFetched = time.Now()
+	e.mu.Unlock()
+
+	return nil
+}
+
+// RateFor returnerar SEK per EUR för leveransdagen. ECB publicerar inte kurser för helger
+// eller framtida dagar, så senaste kursen på eller före dagen används.
+// Kurserna hämtas om från ECB om de är äldre än 12 timmar.
+func (e *ExchangeRateService) RateFor(day time.Time) (float64, error) {
+	e.mu.Lock()
+	stale := time.Since(e.lastFetched) > 12*time.Hour
+	e.mu.Unlock()
+
+	if stale {
+		if err := e.Refresh(); err != nil {
+			log.Printf("Failed to refresh exchange rates, using stored: %v", err)
+		}
+	}
+
+	rate, _, err := e.db.GetExchangeRate("SEK", day.In(time.Local).Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+	if rate == 0 {
+		log.Printf("No EUR/SEK rate stored for %s, using default %.2f", day.Format("2006-01-02"), DefaultEURSEK)
+		return DefaultEURSEK, nil
+	}
+
+	return rate, nil
+}
+
+// eurMWhToOreInclVAT konverterar EUR/MWh till öre/kWh inkl 25% moms.
+// Exempel: 14.18 EUR/MWh med kurs 11.0 -> 0.01418 EUR/kWh -> 0.156 SEK/kWh -> 15.6 öre/kWh -> 19.5 öre/kWh
+func eurMWhToOreInclVAT(eurPerMWh, sekPerEUR float64) int {
+	const VAT = 1.25 // 25% moms
+	return int(eurPerMWh / 1000.0 * sekPerEUR * 100.0 * VAT)
+}
+
+// rateForDay slår upp kursen för en leveransdag, med default om källa saknas eller felar
+func rateForDay(rates ExchangeRateSource, t time.Time) float64 {
+	if rates == nil {
+		return DefaultEURSEK
+	}
+	rate, err := rates.RateFor(t)
+	if err != nil {
+		log.Printf("Failed to look up exchange rate, using default %.2f: %v", DefaultEURSEK, err)
+		return DefaultEURSEK
+	}
+	return rate
+}
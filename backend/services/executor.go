@@ -0,0 +1,218 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"battery-scheduler/db"
+)
+
+// ExecutorConfig beskriver hur aktuellt läge skickas till Home Assistant
+type ExecutorConfig struct {
+	Service       string         // Tjänst som anropas, t.ex. "input_select.select_option" eller "script.turn_on"
+	EntityID      string         // Entitet som tjänsten styr, t.ex. "input_select.battery_mode"
+	StateEntityID string         // Entitet som läses tillbaka för bekräftelse, tomt = ingen bekräftelse
+	Options       map[int]string // Läge -> option/state i Home Assistant, saknas = lägesnumret
+	Retries       int            // Antal försök per lägesbyte
+	RetryDelay    time.Duration  // Väntetid efter första misslyckade försöket, ökar linjärt
+	ConfirmDelay  time.Duration  // Väntetid innan state läses tillbaka
+}
+
+// ModeExecutor skickar aktuellt läge till Home Assistant vid varje brytpunkt i schemat,
+// så att batteriet inte blir kvar i gammalt läge om Home Assistant slutar polla.
+type ModeExecutor struct {
+	scheduler *SchedulerService
+	ha        *HomeAssistantService
+	db        *db.Database
+	config    ExecutorConfig
+
+	wake       chan struct{}
+	applied    int // Senast bekräftade läge, 0 = inget ännu
+	failedMode int // Läge vars misslyckande redan loggats i history
+}
+
+// NewModeExecutor skapar en executor. Tomt StateEntityID sätts till EntityID för
+// input_select/select/input_number/number där entitetens state är själva läget.
+func NewModeExecutor(scheduler *SchedulerService, ha *HomeAssistantService, database *db.Database, config ExecutorConfig) *ModeExecutor {
+	if config.Retries <= 0 {
+		config.Retries = 3
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = 10 * time.Second
+	}
+	if config.ConfirmDelay <= 0 {
+		config.ConfirmDelay = 2 * time.Second
+	}
+	if config.StateEntityID == "" {
+		switch serviceDomain(config.Service) {
+		case "input_select", "select", "input_number", "number":
+			config.StateEntityID = config.EntityID
+		}
+	}
+
+	return &ModeExecutor{
+		scheduler: scheduler,
+		ha:        ha,
+		db:        database,
+		config:    config,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// ParseModeOptions tolkar "1:Passiv,2:Ladda,..." till läge -> option
+func ParseModeOptions(value string) (map[int]string, error) {
+	options := make(map[int]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.SplitN(part, ":", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("ogiltig lägesmappning: %q", part)
+		}
+		mode, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+		if err != nil || mode < 1 || mode > 6 {
+			return nil, fmt.Errorf("ogiltigt läge i lägesmappning: %q", part)
+		}
+		options[mode] = strings.TrimSpace(fields[1])
+	}
+	return options, nil
+}
+
+// Notify väcker executorn direkt, t.ex. när schemat har ändrats
+func (e *ModeExecutor) Notify() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run kör executorn tills programmet avslutas. Läget kontrolleras vid varje brytpunkt,
+// när Notify anropas och annars varje minut (så att misslyckade byten försöks igen).
+func (e *ModeExecutor) Run() {
+	for {
+		current := e.scheduler.GetCurrentMode(time.Now())
+		if current.Mode != e.applied {
+			e.apply(current.Mode)
+		}
+
+		wait := time.Minute
+		if !current.NextChange.IsZero() {
+			if until := time.Until(current.NextChange); until < wait {
+				wait = until
+			}
+		}
+		if wait < time.Second {
+			wait = time.Second
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-e.wake:
+		}
+	}
+}
+
+// apply skickar ett läge till Home Assistant med omförsök och loggar resultatet i history
+func (e *ModeExecutor) apply(mode int) {
+	previous := e.applied
+
+	var err error
+	for attempt := 1; attempt <= e.config.Retries; attempt++ {
+		if err = e.push(mode); err == nil {
+			break
+		}
+		log.Printf("Failed to set mode %d in Home Assistant (attempt %d/%d): %v", mode, attempt, e.config.Retries, err)
+		if attempt < e.config.Retries {
+			time.Sleep(time.Duration(attempt) * e.config.RetryDelay)
+		}
+	}
+
+	now := time.Now()
+	if err != nil {
+		if e.failedMode != mode {
+			if logErr := e.db.LogModeTransition(now, mode, "mode_change_failed", err.Error()); logErr != nil {
+				log.Printf("Failed to log mode transition: %v", logErr)
+			}
+			e.failedMode = mode
+		}
+		return
+	}
+
+	e.applied = mode
+	e.failedMode = 0
+
+	detail := fmt.Sprintf("%d -> %d via %s", previous, mode, e.config.Service)
+	if e.config.StateEntityID != "" {
+		detail += " (bekräftad)"
+	}
+	log.Printf("Mode changed: %s", detail)
+	if err := e.db.LogModeTransition(now, mode, "mode_change", detail); err != nil {
+		log.Printf("Failed to log mode transition: %v", err)
+	}
+}
+
+// push anropar tjänsten och läser tillbaka state för att bekräfta bytet
+func (e *ModeExecutor) push(mode int) error {
+	option := e.option(mode)
+	if err := e.ha.CallService(e.config.Service, e.serviceData(mode, option)); err != nil {
+		return err
+	}
+
+	if e.config.StateEntityID == "" {
+		return nil
+	}
+
+	time.Sleep(e.config.ConfirmDelay)
+	state, err := e.ha.GetState(e.config.StateEntityID)
+	if err != nil {
+		return fmt.Errorf("failed to confirm mode: %w", err)
+	}
+	if state.State == option {
+		return nil
+	}
+	if value, err := strconv.ParseFloat(state.State, 64); err == nil && int(value) == mode {
+		return nil
+	}
+	return fmt.Errorf("%s is %q, expected %q", e.config.StateEntityID, state.State, option)
+}
+
+// option returnerar Home Assistant-värdet för ett läge
+func (e *ModeExecutor) option(mode int) string {
+	if option, ok := e.config.Options[mode]; ok {
+		return option
+	}
+	return strconv.Itoa(mode)
+}
+
+// serviceData bygger anropsdata utifrån vilken typ av tjänst som används
+func (e *ModeExecutor) serviceData(mode int, option string) map[string]interface{} {
+	data := make(map[string]interface{})
+	if e.config.EntityID != "" {
+		data["entity_id"] = e.config.EntityID
+	}
+
+	switch e.config.Service {
+	case "input_select.select_option", "select.select_option":
+		data["option"] = option
+	case "input_number.set_value", "number.set_value":
+		data["value"] = mode
+	case "script.turn_on":
+		data["variables"] = map[string]interface{}{"mode": mode, "option": option}
+	default:
+		// Egna skript anropade direkt (script.xyz) får variablerna som tjänstdata
+		data["mode"] = mode
+		data["option"] = option
+	}
+
+	return data
+}
+
+func serviceDomain(service string) string {
+	domain, _, _ := strings.Cut(service, ".")
+	return domain
+}
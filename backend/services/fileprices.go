@@ -0,0 +1,130 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+// FilePriceService läser priser från en lokal CSV- eller JSON-fil.
+//
+// CSV: en rad per kvart, "timestamp,price" där timestamp är RFC3339 och price öre/kWh inkl moms.
+// En rubrikrad är tillåten. JSON: samma format som GET /api/prices, [{"timestamp": ..., "price": ...}].
+type FilePriceService struct {
+	path string
+	area string
+}
+
+// NewFilePriceService skapar en ny filbaserad prisprovider
+func NewFilePriceService(path, area string) *FilePriceService {
+	return &FilePriceService{path: path, area: area}
+}
+
+// Name returnerar providerns namn (PriceProvider)
+func (f *FilePriceService) Name() string {
+	return "file"
+}
+
+// FetchPrices läser filen och returnerar priserna i intervallet
+func (f *FilePriceService) FetchPrices(from, to time.Time) ([]models.Price, error) {
+	if f.path == "" {
+		return nil, fmt.Errorf("ingen prisfil konfigurerad")
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open price file: %w", err)
+	}
+	defer file.Close()
+
+	var all []models.Price
+	if strings.EqualFold(filepath.Ext(f.path), ".json") {
+		all, err = f.parseJSON(file)
+	} else {
+		all, err = f.parseCSV(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var prices []models.Price
+	for _, p := range all {
+		if p.Timestamp.Before(from) || !p.Timestamp.Before(to) {
+			continue
+		}
+		p.Timestamp = p.Timestamp.In(time.Local)
+		if p.Area == "" {
+			p.Area = f.area
+		}
+		p.Source = f.Name()
+		prices = append(prices, p)
+	}
+
+	if len(prices) == 0 {
+		return nil, nil
+	}
+
+	// Fyll bara luckor fram till sista kvarten som finns i filen
+	var last time.Time
+	for _, p := range prices {
+		if p.Timestamp.After(last) {
+			last = p.Timestamp
+		}
+	}
+	if end := last.Add(15 * time.Minute); end.Before(to) {
+		to = end
+	}
+
+	return fillPriceGaps(prices, from, to, f.area, f.Name()), nil
+}
+
+func (f *FilePriceService) parseJSON(r io.Reader) ([]models.Price, error) {
+	var prices []models.Price
+	if err := json.NewDecoder(r).Decode(&prices); err != nil {
+		return nil, fmt.Errorf("failed to parse price file: %w", err)
+	}
+	return prices, nil
+}
+
+func (f *FilePriceService) parseCSV(r io.Reader) ([]models.Price, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse price file: %w", err)
+	}
+
+	var prices []models.Price
+	for i, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		timestamp, err := time.Parse(time.RFC3339, record[0])
+		if err != nil {
+			if i == 0 {
+				continue // Rubrikrad
+			}
+			return nil, fmt.Errorf("ogiltig tidsstämpel på rad %d: %q", i+1, record[0])
+		}
+		price, err := strconv.ParseFloat(record[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("ogiltigt pris på rad %d: %q", i+1, record[1])
+		}
+		prices = append(prices, models.Price{
+			Timestamp: timestamp,
+			PriceOre:  int(price),
+		})
+	}
+
+	return prices, nil
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"battery-scheduler/db"
+	"battery-scheduler/models"
+)
+
+// forecastStaleAfter är hur gammal en prognos (räknat från SMHI:s utgivningstid) får vara
+// innan den flaggas som inaktuell. SMHI ger ut nya prognoser varje timme.
+const forecastStaleAfter = 3 * time.Hour
+
+// forecastKeep är hur länge gamla hämtningar sparas i databasen
+const forecastKeep = 7 * 24 * time.Hour
+
+// ForecastStore håller senaste väderprognosen från SMHI i minnet och i databasen,
+// så att förfrågningar inte hämtar från SMHI och senaste lyckade prognos används när SMHI inte svarar
+type ForecastStore struct {
+	db   *db.Database
+	smhi *SMHIService
+
+	mu           sync.Mutex
+	points       []models.ForecastPoint
+	lat, lon     float64 // Platsen som points gäller
+	loaded       bool
+	fetchedAt    time.Time
+	approvedTime time.Time
+	lastAttempt  time.Time
+	lastErr      error
+	staleLogged  bool // Inaktuell prognos loggas en gång tills en färsk hämtats
+}
+
+// NewForecastStore skapar en prognoscache för SMHI-tjänstens plats
+func NewForecastStore(database *db.Database, smhi *SMHIService) *ForecastStore {
+	return &ForecastStore{db: database, smhi: smhi}
+}
+
+// Refresh hämtar en ny prognos från SMHI och sparar den om den är nyare än den sparade.
+// Anropas från cron strax efter SMHI:s utgivningstider.
+func (f *ForecastStore) Refresh() error {
+	lat, lon := f.smhi.Location()
+	points, approved, err := f.smhi.FetchForecast()
+	now := time.Now()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	defer f.logStaleLocked(now)
+
+	f.loadLocked(lat, lon)
+	f.lastAttempt = now
+	f.lastErr = err
+	if err != nil {
+		return err
+	}
+	if len(points) == 0 {
+		f.lastErr = fmt.Errorf("SMHI returned an empty forecast")
+		return f.lastErr
+	}
+
+	if !approved.After(f.approvedTime) {
+		// Samma utgivning som redan sparats, bara hämtningstiden uppdateras
+		f.fetchedAt = now
+		return nil
+	}
+
+	if err := f.db.SaveForecast(now, approved, lat, lon, points, forecastKeep); err != nil {
+		log.Printf("Failed to save SMHI forecast: %v", err)
+	}
+	f.points = points
+	f.fetchedAt = now
+	f.approvedTime = approved
+	return nil
+}
+
+// Forecast returnerar senaste prognosen för aktuell plats och hur färsk den är.
+// Finns ingen prognos alls hämtas en direkt från SMHI.
+func (f *ForecastStore) Forecast() ([]models.ForecastPoint, models.ForecastStatus) {
+	lat, lon := f.smhi.Location()
+
+	f.mu.Lock()
+	f.loadLocked(lat, lon)
+	empty := len(f.points) == 0 && f.lastAttempt.IsZero()
+	f.mu.Unlock()
+
+	if empty {
+		if err := f.Refresh(); err != nil {
+			log.Printf("Failed to fetch SMHI forecast: %v", err)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status := models.ForecastStatus{
+		Source:       "smhi",
+		Lat:          lat,
+		Lon:          lon,
+		ApprovedTime: f.approvedTime,
+		FetchedAt:    f.fetchedAt,
+		LastAttempt:  f.lastAttempt,
+		Stale:        f.staleLocked(time.Now()),
+	}
+	if f.lastErr != nil {
+		status.LastError = f.lastErr.Error()
+		status.Source = "cache"
+	}
+	if len(f.points) == 0 {
+		status.Source = "none"
+	}
+
+	return f.points, status
+}
+
+// staleLocked avgör om prognosen saknas eller är för gammal
+func (f *ForecastStore) staleLocked(now time.Time) bool {
+	return len(f.points) == 0 || now.Sub(f.approvedTime) > forecastStaleAfter
+}
+
+// logStaleLocked loggar när en hämtning upptäcker att prognosen blivit inaktuell, och när den är färsk igen
+func (f *ForecastStore) logStaleLocked(now time.Time) {
+	stale := f.staleLocked(now)
+	if stale && !f.staleLogged {
+		approved := "never"
+		if !f.approvedTime.IsZero() {
+			approved = f.approvedTime.Format(time.RFC3339)
+		}
+		if f.lastErr != nil {
+			log.Printf("SMHI forecast is stale (approved %s): %v", approved, f.lastErr)
+		} else {
+			log.Printf("SMHI forecast is stale (approved %s)", approved)
+		}
+	} else if !stale && f.staleLogged {
+		log.Printf("SMHI forecast is fresh again (approved %s)", f.approvedTime.Format(time.RFC3339))
+	}
+	f.staleLogged = stale
+}
+
+// loadLocked läser sparad prognos från databasen första gången och när platsen har bytts
+func (f *ForecastStore) loadLocked(lat, lon float64) {
+	if f.loaded && f.lat == lat && f.lon == lon {
+		return
+	}
+
+	fetchedAt, approved, points, err := f.db.GetLatestForecast(lat, lon)
+	if err != nil {
+		log.Printf("Failed to load stored SMHI forecast: %v", err)
+	}
+	f.points = points
+	f.fetchedAt = fetchedAt
+	f.approvedTime = approved
+	f.lat, f.lon = lat, lon
+	f.loaded = true
+
+	// Hämtningsstatus gällde förra platsen
+	f.lastAttempt = time.Time{}
+	f.lastErr = nil
+}
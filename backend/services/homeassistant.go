@@ -1,11 +1,14 @@
 package services
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,9 +20,10 @@ type HomeAssistantService struct {
 
 // HAStateResponse representerar ett state-svar från Home Assistant
 type HAStateResponse struct {
-	EntityID    string    `json:"entity_id"`
-	State       string    `json:"state"`
-	LastChanged time.Time `json:"last_changed"`
+	EntityID    string                 `json:"entity_id"`
+	State       string                 `json:"state"`
+	Attributes  map[string]interface{} `json:"attributes"`
+	LastChanged time.Time              `json:"last_changed"`
 }
 
 // NewHomeAssistantService skapar en ny Home Assistant-tjänst
@@ -30,45 +34,195 @@ func NewHomeAssistantService(baseURL, token string) *HomeAssistantService {
 	}
 }
 
+// Configured anger om URL och token finns
+func (h *HomeAssistantService) Configured() bool {
+	return h.baseURL != "" && h.token != ""
+}
+
+// socEntity är Ferroamp-sensorn för batteriets laddnivå
+const socEntity = "sensor.ferroamp_system_state_of_charge"
+
 // GetSoC hämtar aktuell State of Charge från Ferroamp-sensorn
 func (h *HomeAssistantService) GetSoC() (float64, time.Time, error) {
-	if h.baseURL == "" || h.token == "" {
-		return 0, time.Time{}, fmt.Errorf("Home Assistant not configured")
+	state, err := h.GetState(socEntity)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	soc, err := strconv.ParseFloat(state.State, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to parse SoC value '%s': %w", state.State, err)
 	}
 
-	url := fmt.Sprintf("%s/api/states/sensor.ferroamp_system_state_of_charge", h.baseURL)
+	return soc, state.LastChanged, nil
+}
+
+// GetPowerKW hämtar en effektsensor och returnerar värdet i kW (W och kW hanteras via unit_of_measurement)
+func (h *HomeAssistantService) GetPowerKW(entityID string) (float64, time.Time, error) {
+	state, err := h.GetState(entityID)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	value, err := strconv.ParseFloat(state.State, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to parse value '%s' from %s: %w", state.State, entityID, err)
+	}
+
+	if unit, _ := state.Attributes["unit_of_measurement"].(string); unit == "W" {
+		value /= 1000
+	}
+
+	return value, state.LastChanged, nil
+}
+
+// GetState hämtar state för en entitet från Home Assistant
+func (h *HomeAssistantService) GetState(entityID string) (*HAStateResponse, error) {
+	if !h.Configured() {
+		return nil, fmt.Errorf("Home Assistant not configured")
+	}
+
+	url := fmt.Sprintf("%s/api/states/%s", h.baseURL, entityID)
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return 0, time.Time{}, fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Authorization", "Bearer "+h.token)
 
 	client := &http.Client{Timeout: 10 * time.Second}
+	start := time.Now()
 	resp, err := client.Do(req)
+	observeRequest("homeassistant", "state", start, resp, err)
 	if err != nil {
-		return 0, time.Time{}, fmt.Errorf("failed to fetch SoC from Home Assistant: %w", err)
+		return nil, fmt.Errorf("failed to fetch %s from Home Assistant: %w", entityID, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, time.Time{}, fmt.Errorf("Home Assistant returned status %d", resp.StatusCode)
+		return nil, fmt.Errorf("Home Assistant returned status %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, time.Time{}, fmt.Errorf("failed to read response: %w", err)
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
 	var state HAStateResponse
 	if err := json.Unmarshal(body, &state); err != nil {
-		return 0, time.Time{}, fmt.Errorf("failed to parse response: %w", err)
+		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	soc, err := strconv.ParseFloat(state.State, 64)
+	return &state, nil
+}
+
+// CallService anropar en tjänst i Home Assistant, t.ex. "input_select.select_option"
+func (h *HomeAssistantService) CallService(service string, data map[string]interface{}) error {
+	if !h.Configured() {
+		return fmt.Errorf("Home Assistant not configured")
+	}
+
+	domain, name, ok := strings.Cut(service, ".")
+	if !ok || domain == "" || name == "" {
+		return fmt.Errorf("invalid service %q, expected domain.service", service)
+	}
+
+	payload, err := json.Marshal(data)
 	if err != nil {
-		return 0, time.Time{}, fmt.Errorf("failed to parse SoC value '%s': %w", state.State, err)
+		return fmt.Errorf("failed to encode service data: %w", err)
 	}
 
-	return soc, state.LastChanged, nil
+	url := fmt.Sprintf("%s/api/services/%s/%s", h.baseURL, domain, name)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+h.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	start := time.Now()
+	resp, err := client.Do(req)
+	observeRequest("homeassistant", "service", start, resp, err)
+	if err != nil {
+		return fmt.Errorf("failed to call %s in Home Assistant: %w", service, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Home Assistant returned status %d for %s: %s", resp.StatusCode, service, string(body))
+	}
+
+	return nil
+}
+
+// HAHistoryPoint är ett värde ur Home Assistants historik
+type HAHistoryPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// GetHistory hämtar numerisk historik för en entitet mellan from och to.
+// Värden som inte är tal (t.ex. "unavailable") hoppas över. Effekt i W räknas om till kW.
+func (h *HomeAssistantService) GetHistory(entityID string, from, to time.Time) ([]HAHistoryPoint, error) {
+	if !h.Configured() {
+		return nil, fmt.Errorf("Home Assistant not configured")
+	}
+
+	params := url.Values{}
+	params.Set("filter_entity_id", entityID)
+	params.Set("end_time", to.UTC().Format(time.RFC3339))
+	params.Set("minimal_response", "")
+	reqURL := fmt.Sprintf("%s/api/history/period/%s?%s", h.baseURL, from.UTC().Format(time.RFC3339), params.Encode())
+
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+h.token)
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	start := time.Now()
+	resp, err := client.Do(req)
+	observeRequest("homeassistant", "history", start, resp, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch history for %s from Home Assistant: %w", entityID, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Home Assistant returned status %d", resp.StatusCode)
+	}
+
+	// Svaret är en lista per entitet. Med minimal_response har bara första värdet attribut.
+	var series [][]struct {
+		State       string                 `json:"state"`
+		Attributes  map[string]interface{} `json:"attributes"`
+		LastChanged time.Time              `json:"last_changed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&series); err != nil {
+		return nil, fmt.Errorf("failed to parse history: %w", err)
+	}
+	if len(series) == 0 {
+		return nil, nil
+	}
+
+	scale := 1.0
+	if len(series[0]) > 0 {
+		if unit, _ := series[0][0].Attributes["unit_of_measurement"].(string); unit == "W" {
+			scale = 0.001
+		}
+	}
+
+	var points []HAHistoryPoint
+	for _, s := range series[0] {
+		value, err := strconv.ParseFloat(s.State, 64)
+		if err != nil {
+			continue
+		}
+		points = append(points, HAHistoryPoint{Time: s.LastChanged, Value: value * scale})
+	}
+
+	return points, nil
 }
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics är registret som tjänsterna rapporterar räknare och svarstider till. Det skrivs ut av /metrics.
+var Metrics = NewMetricsRegistry()
+
+// requestBuckets är histogramgränserna (sekunder) för svarstider mot externa tjänster
+var requestBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// MetricsRegistry håller mätvärden i minnet och skriver dem i Prometheus textformat (0.0.4).
+// Säker att använda från flera goroutines.
+type MetricsRegistry struct {
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	name, help, kind string // kind: counter, gauge eller histogram
+	series           map[string]*metricSeries
+}
+
+type metricSeries struct {
+	labels  string // Färdigformaterade etiketter, t.ex. provider="entsoe"
+	value   float64
+	buckets []uint64 // Bara histogram, antal observationer <= respektive gräns
+	sum     float64
+	count   uint64
+}
+
+// NewMetricsRegistry skapar ett tomt register
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{families: make(map[string]*metricFamily)}
+}
+
+// IncCounter räknar upp en räknare med etiketter i par (namn, värde, namn, värde ...)
+func (r *MetricsRegistry) IncCounter(name, help string, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.series(name, help, "counter", labels).value++
+}
+
+// SetGauge sätter ett mätvärde som kan gå upp och ner
+func (r *MetricsRegistry) SetGauge(name, help string, value float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.series(name, help, "gauge", labels).value = value
+}
+
+// ObserveDuration lägger en svarstid i ett histogram
+func (r *MetricsRegistry) ObserveDuration(name, help string, d time.Duration, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.series(name, help, "histogram", labels)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(requestBuckets))
+	}
+	seconds := d.Seconds()
+	for i, bound := range requestBuckets {
+		if seconds <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += seconds
+	s.count++
+}
+
+func (r *MetricsRegistry) series(name, help, kind string, labels []string) *metricSeries {
+	f, ok := r.families[name]
+	if !ok {
+		f = &metricFamily{name: name, help: help, kind: kind, series: make(map[string]*metricSeries)}
+		r.families[name] = f
+	}
+	key := formatLabels(labels)
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labels: key}
+		f.series[key] = s
+	}
+	return s
+}
+
+// WriteText skriver alla mätvärden i Prometheus textformat, sorterade på namn och etiketter
+func (r *MetricsRegistry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.kind)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind != "histogram" {
+				fmt.Fprintf(&b, "%s%s %s\n", name, wrapLabels(s.labels), formatMetricValue(s.value))
+				continue
+			}
+			for i, bound := range requestBuckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(s.labels, `le="`+formatMetricValue(bound)+`"`)), s.buckets[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(s.labels, `le="+Inf"`)), s.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", name, wrapLabels(s.labels), formatMetricValue(s.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", name, wrapLabels(s.labels), s.count)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// observeRequest registrerar svarstid och utfall för ett anrop till en extern tjänst
+func observeRequest(service, operation string, start time.Time, resp *http.Response, err error) {
+	result := "success"
+	if err != nil || resp == nil || resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		result = "error"
+	}
+	Metrics.ObserveDuration(
+		"battery_scheduler_external_request_duration_seconds",
+		"Duration of requests to external services (SMHI, Home Assistant, Pushover).",
+		time.Since(start),
+		"service", service, "operation", operation, "result", result,
+	)
+}
+
+func formatLabels(labels []string) string {
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return strings.Join(parts, ",")
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
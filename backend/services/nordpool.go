@@ -0,0 +1,154 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+// DefaultNordPoolURL är Nord Pools publika day-ahead-API
+const DefaultNordPoolURL = "https://dataportal-api.nordpoolgroup.com/api/DayAheadPrices"
+
+// NordPoolService hämtar day-ahead-priser från Nord Pools JSON-API
+type NordPoolService struct {
+	baseURL string
+	area    string // SE1, SE2, SE3, SE4
+	rates   ExchangeRateSource
+	client  *http.Client
+}
+
+// NordPoolResponse representerar svaret från DayAheadPrices
+type NordPoolResponse struct {
+	DeliveryDateCET  string          `json:"deliveryDateCET"`
+	Currency         string          `json:"currency"`
+	MultiAreaEntries []NordPoolEntry `json:"multiAreaEntries"`
+}
+
+type NordPoolEntry struct {
+	DeliveryStart time.Time          `json:"deliveryStart"`
+	DeliveryEnd   time.Time          `json:"deliveryEnd"`
+	EntryPerArea  map[string]float64 `json:"entryPerArea"`
+}
+
+// NewNordPoolService skapar en ny Nord Pool-provider. Tom baseURL ger DefaultNordPoolURL.
+// Priser hämtas i EUR och räknas om med rates, så att råvärdet kan sparas som för Entsoe.
+func NewNordPoolService(baseURL, area string, rates ExchangeRateSource) *NordPoolService {
+	if baseURL == "" {
+		baseURL = DefaultNordPoolURL
+	}
+	return &NordPoolService{
+		baseURL: baseURL,
+		area:    area,
+		rates:   rates,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Name returnerar providerns namn (PriceProvider)
+func (n *NordPoolService) Name() string {
+	return "nordpool"
+}
+
+// FetchPrices hämtar priser för varje leveransdag i intervallet
+func (n *NordPoolService) FetchPrices(from, to time.Time) ([]models.Price, error) {
+	var prices []models.Price
+
+	for day := startOfDay(from); day.Before(to); day = day.AddDate(0, 0, 1) {
+		dayPrices, err := n.fetchDay(day)
+		if err != nil {
+			// Morgondagens priser finns inte före ca 13:00, behåll det vi fått
+			if len(prices) > 0 {
+				break
+			}
+			return nil, err
+		}
+		prices = append(prices, dayPrices...)
+	}
+
+	var inRange []models.Price
+	for _, p := range prices {
+		if !p.Timestamp.Before(from) && p.Timestamp.Before(to) {
+			inRange = append(inRange, p)
+		}
+	}
+	if len(inRange) == 0 {
+		return nil, nil
+	}
+
+	// Fyll bara luckor fram till sista levererade kvarten, inte in i en dag som saknas
+	last := inRange[len(inRange)-1].Timestamp.Add(15 * time.Minute)
+	if last.Before(to) {
+		to = last
+	}
+
+	return fillPriceGaps(inRange, from, to, n.area, n.Name()), nil
+}
+
+// fetchDay hämtar priser för en leveransdag
+func (n *NordPoolService) fetchDay(day time.Time) ([]models.Price, error) {
+	query := url.Values{}
+	query.Set("date", day.Format("2006-01-02"))
+	query.Set("market", "DayAhead")
+	query.Set("deliveryArea", n.area)
+	query.Set("currency", "EUR")
+
+	resp, err := n.client.Get(n.baseURL + "?" + query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch from Nord Pool: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, fmt.Errorf("Nord Pool har inga priser för %s ännu", day.Format("2006-01-02"))
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Nord Pool API error (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Nord Pool response: %w", err)
+	}
+
+	var npResp NordPoolResponse
+	if err := json.Unmarshal(body, &npResp); err != nil {
+		return nil, fmt.Errorf("failed to parse Nord Pool response: %w", err)
+	}
+
+	rate := rateForDay(n.rates, day)
+
+	var prices []models.Price
+	for _, entry := range npResp.MultiAreaEntries {
+		eurPerMWh, ok := entry.EntryPerArea[n.area]
+		if !ok {
+			continue
+		}
+		priceOreInclMoms := eurMWhToOreInclVAT(eurPerMWh, rate)
+
+		// Timpriser (äldre leveranser) delas upp i fyra kvartar
+		for ts := entry.DeliveryStart; ts.Before(entry.DeliveryEnd); ts = ts.Add(15 * time.Minute) {
+			raw := eurPerMWh
+			prices = append(prices, models.Price{
+				Timestamp:   ts.In(time.Local),
+				PriceOre:    priceOreInclMoms,
+				PriceEurMWh: &raw,
+				Area:        n.area,
+				Source:      n.Name(),
+			})
+		}
+	}
+
+	return prices, nil
+}
+
+// startOfDay returnerar midnatt lokal tid för dagen som t infaller på
+func startOfDay(t time.Time) time.Time {
+	t = t.In(time.Local)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+// Lägen som optimeraren själv kan välja mellan
+const (
+	modePassive   = 1
+	modeCharge    = 2
+	modeDischarge = 3
+)
+
+// socStep är upplösningen (procentenheter) i optimerarens SoC-rutnät
+const socStep = 0.05
+
+// OptimizerParams är indata till schemaoptimeringen
+type OptimizerParams struct {
+	Prices           []models.Price         // Kvartspriser från och med aktuell kvart (TotalOre används om satt)
+	Estimates        []models.PowerEstimate // Förbruknings- och solelsprognos, matchas mot Prices via tidsstämpel
+	FixedModes       map[int64]int          // Kvartar (Unix-tid) som redan är låsta (t.ex. laddbox), läget behålls
+	StartSoC         float64                // Aktuell laddnivå i procent
+	Battery          *BatterySimulator      // Batterimodell (kapacitet, min-SoC, laddkurva)
+	TerminalValueOre float64                // Värde (öre/kWh) för energi som finns kvar i batteriet vid horisontens slut
+}
+
+// OptimizerResult är resultatet av en optimering
+type OptimizerResult struct {
+	Schedule        []models.ScheduleChange `json:"schedule"`
+	Modes           []int                   `json:"modes"`
+	SoC             []float64               `json:"soc"`
+	CostOre         float64                 `json:"cost_ore"`
+	BaselineCostOre float64                 `json:"baseline_cost_ore"`
+}
+
+// OptimizeSchedule räknar fram det kostnadsminimerande schemat med dynamisk programmering.
+// Tillståndet är batteriets laddnivå (diskretiserad i socStep), och varje kvart kan batteriet
+// vara passivt, ladda från nätet eller urladda till fastigheten.
+func OptimizeSchedule(p OptimizerParams) (*OptimizerResult, error) {
+	n := len(p.Prices)
+	if n == 0 {
+		return nil, fmt.Errorf("inga priser att optimera mot")
+	}
+	if p.Battery == nil || p.Battery.CapacityKWh <= 0 {
+		return nil, fmt.Errorf("ogiltig batterimodell")
+	}
+	capacity := p.Battery.CapacityKWh
+
+	load := make([]float64, n)
+	estimateByTime := make(map[int64]float64, len(p.Estimates))
+	for _, e := range p.Estimates {
+		estimateByTime[e.Timestamp.Unix()] = e.PowerKW - e.SolarKW // Nettolast
+	}
+	for i, price := range p.Prices {
+		if kw, ok := estimateByTime[price.Timestamp.Unix()]; ok {
+			load[i] = kw
+		} else {
+			load[i] = 1.0 // Samma fallback som frontendens simulering
+		}
+	}
+
+	states := int(math.Round(100/socStep)) + 1
+	minIdx := int(math.Ceil(p.Battery.MinSoC / socStep))
+	startIdx := clampInt(int(math.Round(p.StartSoC/socStep)), 0, states-1)
+
+	// cost[t][s] = minsta kostnad från kvart t till slutet givet laddnivå s
+	cost := make([][]float64, n+1)
+	choice := make([][]int, n)
+	for t := range cost {
+		cost[t] = make([]float64, states)
+	}
+	for s := 0; s < states; s++ {
+		storedKWh := math.Max(0, float64(s-minIdx)*socStep/100*capacity)
+		cost[n][s] = -storedKWh * p.TerminalValueOre
+	}
+
+	for t := n - 1; t >= 0; t-- {
+		choice[t] = make([]int, states)
+		price := effectivePriceOre(p.Prices[t])
+		fixed, isFixed := p.FixedModes[p.Prices[t].Timestamp.Unix()]
+
+		for s := 0; s < states; s++ {
+			best := math.Inf(1)
+			bestMode := modePassive
+
+			for _, mode := range []int{modePassive, modeCharge, modeDischarge} {
+				if isFixed && mode != modePassive {
+					continue
+				}
+				next, gridKWh := p.transition(s, mode, load[t], states)
+				c := gridKWh*price + cost[t+1][next]
+				// Strikt mindre än gör att passivt läge vinner vid lika kostnad
+				if c < best-1e-9 {
+					best = c
+					bestMode = mode
+				}
+			}
+
+			if isFixed {
+				bestMode = fixed
+			}
+			cost[t][s] = best
+			choice[t][s] = bestMode
+		}
+	}
+
+	result := &OptimizerResult{
+		Modes: make([]int, n),
+		SoC:   make([]float64, n),
+	}
+
+	s := startIdx
+	for t := 0; t < n; t++ {
+		mode := choice[t][s]
+		result.Modes[t] = mode
+
+		stepMode := mode
+		if mode != modeCharge && mode != modeDischarge {
+			stepMode = modePassive
+		}
+		next, gridKWh := p.transition(s, stepMode, load[t], states)
+		result.CostOre += gridKWh * effectivePriceOre(p.Prices[t])
+		result.BaselineCostOre += math.Max(0, load[t]) * 0.25 * effectivePriceOre(p.Prices[t])
+
+		s = next
+		result.SoC[t] = float64(s) * socStep
+	}
+
+	times := make([]time.Time, n)
+	for i, price := range p.Prices {
+		times[i] = price.Timestamp
+	}
+	result.Schedule = ModesToSchedule(times, result.Modes)
+
+	return result, nil
+}
+
+// transition simulerar en kvart från SoC-index s och avrundar resultatet till rutnätet.
+// Returnerar nästa index och energin som köps från nätet under kvarten.
+func (p OptimizerParams) transition(s, mode int, loadKW float64, states int) (int, float64) {
+	next, gridKWh := p.Battery.Step(float64(s)*socStep, mode, loadKW)
+	return clampInt(int(math.Round(next/socStep)), 0, states-1), gridKWh
+}
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
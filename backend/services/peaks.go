@@ -0,0 +1,279 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"battery-scheduler/db"
+	"battery-scheduler/models"
+)
+
+// modePeakLimit är läge 4, effektbegränsning
+const modePeakLimit = 4
+
+// PeakRules beskriver hur nätägaren räknar effekttariffen
+type PeakRules struct {
+	TopN        int     `json:"top_n"`        // Antal toppar per månad som medelvärdet räknas på
+	OnePerDay   bool    `json:"one_per_day"`  // Högst en topp per dygn
+	NightWeight float64 `json:"night_weight"` // Vikt för timmar nattetid (t.ex. 0.5)
+	NightStart  int     `json:"night_start"`  // Första natt-timmen (t.ex. 22)
+	NightEnd    int     `json:"night_end"`    // Timmen då natten slutar (t.ex. 6)
+	Months      []int   `json:"months"`       // Månader då effekttariffen gäller, tomt = alla
+	FloorKW     float64 `json:"floor_kw"`     // Effekt under detta räknas aldrig som ny topp vid planering
+}
+
+// DefaultPeakRules är en vanlig modell: medel av tre högsta timmar, en per dygn, halv vikt 22-06
+var DefaultPeakRules = PeakRules{
+	TopN:        3,
+	OnePerDay:   true,
+	NightWeight: 0.5,
+	NightStart:  22,
+	NightEnd:    6,
+}
+
+// Weight returnerar viktningen för en timme (lokal tid)
+func (r PeakRules) Weight(hour time.Time) float64 {
+	h := hour.In(time.Local).Hour()
+	night := false
+	if r.NightStart > r.NightEnd {
+		night = h >= r.NightStart || h < r.NightEnd
+	} else if r.NightStart < r.NightEnd {
+		night = h >= r.NightStart && h < r.NightEnd
+	}
+	if night {
+		return r.NightWeight
+	}
+	return 1
+}
+
+// Applies avgör om effekttariffen gäller den månad timmen infaller i
+func (r PeakRules) Applies(hour time.Time) bool {
+	return len(r.Months) == 0 || containsInt(r.Months, int(hour.In(time.Local).Month()))
+}
+
+// PeakTracker samplar nätimport från Home Assistant och sparar medeleffekt per timme
+type PeakTracker struct {
+	db       *db.Database
+	ha       *HomeAssistantService
+	entityID string
+
+	mu    sync.Mutex
+	hour  time.Time
+	sum   float64
+	count int
+}
+
+// NewPeakTracker skapar en tracker för effektsensorn entityID (nätimport, W eller kW)
+func NewPeakTracker(database *db.Database, ha *HomeAssistantService, entityID string) *PeakTracker {
+	return &PeakTracker{
+		db:       database,
+		ha:       ha,
+		entityID: entityID,
+	}
+}
+
+// Sample läser aktuell nätimport och lägger den i pågående timme. Anropas varje minut.
+// När timmen byts sparas föregående timmes medelvärde.
+func (p *PeakTracker) Sample() error {
+	powerKW, _, err := p.ha.GetPowerKW(p.entityID)
+	if err != nil {
+		return err
+	}
+	if powerKW < 0 {
+		powerKW = 0 // Export räknas inte som effektuttag
+	}
+
+	now := time.Now()
+	hour := now.Truncate(time.Hour)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.hour.IsZero() && !hour.Equal(p.hour) {
+		if err := p.flushLocked(); err != nil {
+			log.Printf("Failed to save hourly power for %s: %v", p.hour.Format("2006-01-02 15:04"), err)
+		}
+	}
+	if !hour.Equal(p.hour) {
+		p.hour = hour
+		p.sum = 0
+		p.count = 0
+	}
+
+	p.sum += powerKW
+	p.count++
+
+	return nil
+}
+
+// Current returnerar pågående timmes medeleffekt hittills
+func (p *PeakTracker) Current() (models.HourlyPower, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.count == 0 {
+		return models.HourlyPower{}, false
+	}
+	return models.HourlyPower{Hour: p.hour, AvgKW: p.sum / float64(p.count), Samples: p.count}, true
+}
+
+func (p *PeakTracker) flushLocked() error {
+	if p.count == 0 {
+		return nil
+	}
+	return p.db.SaveHourlyPower(p.hour, p.sum/float64(p.count), p.count)
+}
+
+// MonthPeaks räknar fram månadens toppar ur timvärden enligt reglerna
+func MonthPeaks(month time.Time, hourly []models.HourlyPower, rules PeakRules) models.PeakSummary {
+	summary := models.PeakSummary{
+		Month: month.Format("2006-01"),
+		Peaks: []models.HourlyPower{},
+	}
+
+	var candidates []models.HourlyPower
+	byDay := make(map[string]int)
+	for _, h := range hourly {
+		if !rules.Applies(h.Hour) {
+			continue
+		}
+		h.WeightedKW = h.AvgKW * rules.Weight(h.Hour)
+
+		if rules.OnePerDay {
+			day := h.Hour.In(time.Local).Format("2006-01-02")
+			if i, ok := byDay[day]; ok {
+				if h.WeightedKW > candidates[i].WeightedKW {
+					candidates[i] = h
+				}
+				continue
+			}
+			byDay[day] = len(candidates)
+		}
+		candidates = append(candidates, h)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].WeightedKW > candidates[j].WeightedKW
+	})
+
+	topN := rules.TopN
+	if topN <= 0 {
+		topN = 1
+	}
+	if len(candidates) > topN {
+		candidates = candidates[:topN]
+	}
+	summary.Peaks = append(summary.Peaks, candidates...)
+
+	if len(candidates) > 0 {
+		var sum float64
+		for _, c := range candidates {
+			sum += c.WeightedKW
+		}
+		summary.AverageKW = sum / float64(len(candidates))
+
+		// Full lista: en ny topp måste slå den lägsta. Annars: håll under månadens högsta hittills.
+		if len(candidates) == topN {
+			summary.ThresholdKW = candidates[len(candidates)-1].WeightedKW
+		} else {
+			summary.ThresholdKW = candidates[0].WeightedKW
+		}
+	}
+	if summary.ThresholdKW < rules.FloorKW {
+		summary.ThresholdKW = rules.FloorKW
+	}
+
+	return summary
+}
+
+// PeakPlanHour är en timme där prognosen skulle ge en ny effekttopp
+type PeakPlanHour struct {
+	Hour       time.Time `json:"hour"`
+	ForecastKW float64   `json:"forecast_kw"`
+	WeightedKW float64   `json:"weighted_kw"`
+}
+
+// PlanPeakLimiting lägger läge 4 på timmar där prognosticerad nätimport (från simuleringen)
+// skulle sätta en ny topp. Bara passiva och laddande kvartar ändras; urladdning och laddbox behålls.
+// Returnerar ett läge per simuleringspunkt och de timmar som flaggats.
+func PlanPeakLimiting(points []models.SimulationPoint, summary models.PeakSummary, rules PeakRules) ([]int, []PeakPlanHour) {
+	modes := make([]int, len(points))
+	for i, p := range points {
+		modes[i] = p.Mode
+	}
+
+	// Högsta registrerade viktade timme per dygn, för regeln en topp per dygn
+	dayMax := make(map[string]float64)
+	for _, peak := range summary.Peaks {
+		day := peak.Hour.In(time.Local).Format("2006-01-02")
+		if peak.WeightedKW > dayMax[day] {
+			dayMax[day] = peak.WeightedKW
+		}
+	}
+
+	// Gruppera kvartar per timme
+	var hours []time.Time
+	quarters := make(map[int64][]int)
+	for i, p := range points {
+		hour := p.Timestamp.Truncate(time.Hour)
+		if _, ok := quarters[hour.Unix()]; !ok {
+			hours = append(hours, hour)
+		}
+		quarters[hour.Unix()] = append(quarters[hour.Unix()], i)
+	}
+
+	var flagged []PeakPlanHour
+	for _, hour := range hours {
+		if !rules.Applies(hour) {
+			continue
+		}
+
+		idx := quarters[hour.Unix()]
+		var gridKWh float64
+		for _, i := range idx {
+			gridKWh += points[i].GridKWh
+		}
+		forecastKW := gridKWh / (float64(len(idx)) * 0.25)
+		weighted := forecastKW * rules.Weight(hour)
+
+		threshold := summary.ThresholdKW
+		if rules.OnePerDay {
+			if m := dayMax[hour.In(time.Local).Format("2006-01-02")]; m > threshold {
+				threshold = m
+			}
+		}
+		if weighted <= threshold {
+			continue
+		}
+
+		changed := false
+		for _, i := range idx {
+			if modes[i] == modePassive || modes[i] == modeCharge {
+				modes[i] = modePeakLimit
+				changed = true
+			}
+		}
+		if changed {
+			flagged = append(flagged, PeakPlanHour{Hour: hour, ForecastKW: forecastKW, WeightedKW: weighted})
+		}
+	}
+
+	return modes, flagged
+}
+
+// ValidatePeakRules kontrollerar att reglerna är rimliga
+func ValidatePeakRules(r PeakRules) error {
+	if r.TopN < 1 {
+		return fmt.Errorf("top_n måste vara minst 1")
+	}
+	if r.NightWeight < 0 || r.NightWeight > 1 {
+		return fmt.Errorf("night_weight måste vara mellan 0 och 1")
+	}
+	if r.NightStart < 0 || r.NightStart > 23 || r.NightEnd < 0 || r.NightEnd > 23 {
+		return fmt.Errorf("night_start och night_end måste vara 0-23")
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+var peakDay = time.Date(2025, 1, 15, 0, 0, 0, 0, time.Local)
+
+func peakHour(day, hour int) time.Time {
+	return peakDay.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour)
+}
+
+func TestMonthPeaks(t *testing.T) {
+	hourly := []models.HourlyPower{
+		{Hour: peakHour(0, 8), AvgKW: 6},
+		{Hour: peakHour(0, 18), AvgKW: 8},  // Samma dygn, bara den högsta räknas
+		{Hour: peakHour(1, 23), AvgKW: 10}, // Natt, halv vikt
+		{Hour: peakHour(2, 17), AvgKW: 7},
+		{Hour: peakHour(3, 12), AvgKW: 4},
+	}
+
+	tests := []struct {
+		name      string
+		hourly    []models.HourlyPower
+		rules     PeakRules
+		peaks     []float64 // Viktade toppar, högst först
+		average   float64
+		threshold float64
+	}{
+		{
+			name:      "three highest, one per day, night at half weight",
+			hourly:    hourly,
+			rules:     DefaultPeakRules,
+			peaks:     []float64{8, 7, 5},
+			average:   20.0 / 3,
+			threshold: 5,
+		},
+		{
+			name:      "fewer peaks than top_n keeps below the highest",
+			hourly:    hourly[:2],
+			rules:     DefaultPeakRules,
+			peaks:     []float64{8},
+			average:   8,
+			threshold: 8,
+		},
+		{
+			name:      "floor raises the threshold",
+			hourly:    hourly,
+			rules:     PeakRules{TopN: 3, OnePerDay: true, NightWeight: 0.5, NightStart: 22, NightEnd: 6, FloorKW: 6},
+			peaks:     []float64{8, 7, 5},
+			average:   20.0 / 3,
+			threshold: 6,
+		},
+		{
+			name:   "months without tariff are ignored",
+			hourly: hourly,
+			rules:  PeakRules{TopN: 3, Months: []int{11, 12}},
+			peaks:  []float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := MonthPeaks(peakDay, tt.hourly, tt.rules)
+			peaks := []float64{}
+			for _, p := range summary.Peaks {
+				peaks = append(peaks, p.WeightedKW)
+			}
+			if !reflect.DeepEqual(peaks, tt.peaks) {
+				t.Errorf("peaks = %v, want %v", peaks, tt.peaks)
+			}
+			if diff := summary.AverageKW - tt.average; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("average = %v, want %v", summary.AverageKW, tt.average)
+			}
+			if summary.ThresholdKW != tt.threshold {
+				t.Errorf("threshold = %v, want %v", summary.ThresholdKW, tt.threshold)
+			}
+		})
+	}
+}
+
+// hourPoints är fyra simuleringskvartar i en timme med samma läge och nätimport (kW)
+func hourPoints(hour time.Time, mode int, gridKW float64) []models.SimulationPoint {
+	points := make([]models.SimulationPoint, 4)
+	for i := range points {
+		points[i] = models.SimulationPoint{
+			Timestamp: hour.Add(time.Duration(i) * 15 * time.Minute),
+			Mode:      mode,
+			GridKWh:   gridKW * 0.25,
+		}
+	}
+	return points
+}
+
+func TestPlanPeakLimiting(t *testing.T) {
+	summary := models.PeakSummary{
+		ThresholdKW: 5,
+		Peaks:       []models.HourlyPower{{Hour: peakHour(0, 8), WeightedKW: 7}},
+	}
+
+	tests := []struct {
+		name    string
+		points  []models.SimulationPoint
+		modes   []int
+		flagged int
+	}{
+		{
+			name:   "below the cap is left alone",
+			points: hourPoints(peakHour(1, 17), modePassive, 5),
+			modes:  []int{1, 1, 1, 1},
+		},
+		{
+			name:    "passive hour over the cap is limited",
+			points:  hourPoints(peakHour(1, 17), modePassive, 6),
+			modes:   []int{4, 4, 4, 4},
+			flagged: 1,
+		},
+		{
+			name:    "charging hour over the cap is limited",
+			points:  hourPoints(peakHour(1, 17), modeCharge, 6),
+			modes:   []int{4, 4, 4, 4},
+			flagged: 1,
+		},
+		{
+			name:   "discharge and EV modes are kept",
+			points: append(hourPoints(peakHour(1, 17), modeDischarge, 6), hourPoints(peakHour(1, 18), 5, 6)...),
+			modes:  []int{3, 3, 3, 3, 5, 5, 5, 5},
+		},
+		{
+			name:   "a day that already has a higher peak uses it as cap",
+			points: hourPoints(peakHour(0, 17), modePassive, 6),
+			modes:  []int{1, 1, 1, 1},
+		},
+		{
+			name:   "night hours count at half weight",
+			points: hourPoints(peakHour(1, 23), modePassive, 8),
+			modes:  []int{1, 1, 1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modes, flagged := PlanPeakLimiting(tt.points, summary, DefaultPeakRules)
+			if !reflect.DeepEqual(modes, tt.modes) {
+				t.Errorf("modes = %v, want %v", modes, tt.modes)
+			}
+			if len(flagged) != tt.flagged {
+				t.Errorf("flagged %d hours, want %d", len(flagged), tt.flagged)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+// PriceProvider är en källa för kvartspriser (öre/kWh inkl moms)
+type PriceProvider interface {
+	Name() string
+	FetchPrices(from, to time.Time) ([]models.Price, error)
+}
+
+// PriceService provar en lista av prisproviders i tur och ordning tills någon levererar priser
+type PriceService struct {
+	providers []PriceProvider
+}
+
+// NewPriceService skapar en PriceService med providers i prioritetsordning
+func NewPriceService(providers ...PriceProvider) *PriceService {
+	return &PriceService{providers: providers}
+}
+
+// Providers returnerar namnen på konfigurerade providers i prioritetsordning
+func (p *PriceService) Providers() []string {
+	names := make([]string, 0, len(p.providers))
+	for _, provider := range p.providers {
+		names = append(names, provider.Name())
+	}
+	return names
+}
+
+// FetchPrices hämtar priser från första provider som lyckas och returnerar även dess namn
+func (p *PriceService) FetchPrices(from, to time.Time) ([]models.Price, string, error) {
+	if len(p.providers) == 0 {
+		return nil, "", fmt.Errorf("inga prisproviders konfigurerade")
+	}
+
+	var errs []string
+	for _, provider := range p.providers {
+		prices, err := provider.FetchPrices(from, to)
+		if err != nil {
+			log.Printf("Price provider %s failed: %v", provider.Name(), err)
+			errs = append(errs, fmt.Sprintf("%s: %v", provider.Name(), err))
+			recordPriceFetch(provider.Name(), "failure")
+			continue
+		}
+		if len(prices) == 0 {
+			log.Printf("Price provider %s returned no prices", provider.Name())
+			errs = append(errs, fmt.Sprintf("%s: inga priser", provider.Name()))
+			recordPriceFetch(provider.Name(), "failure")
+			continue
+		}
+		recordPriceFetch(provider.Name(), "success")
+
+		for i := range prices {
+			prices[i].Source = provider.Name()
+		}
+		return prices, provider.Name(), nil
+	}
+
+	return nil, "", fmt.Errorf("alla prisproviders misslyckades: %s", strings.Join(errs, "; "))
+}
+
+// recordPriceFetch räknar prishämtningar per provider och utfall och sparar tiden för senaste
+func recordPriceFetch(provider, result string) {
+	Metrics.IncCounter("battery_scheduler_price_fetch_total", "Price fetch attempts per provider, by result.", "provider", provider, "result", result)
+	Metrics.SetGauge("battery_scheduler_price_fetch_last_timestamp_seconds", "Unix time of the latest price fetch per provider and result.",
+		float64(time.Now().Unix()), "provider", provider, "result", result)
+}
+
+// PriceStats är medel-, min- och maxpris för en serie priser (används i notiser)
+type PriceStats struct {
+	Avg int
+	Min int
+	Max int
+}
+
+// CalculatePriceStats beräknar statistik för en serie priser
+func CalculatePriceStats(prices []models.Price) PriceStats {
+	if len(prices) == 0 {
+		return PriceStats{}
+	}
+
+	var sum int
+	stats := PriceStats{Min: prices[0].PriceOre, Max: prices[0].PriceOre}
+	for _, p := range prices {
+		sum += p.PriceOre
+		if p.PriceOre < stats.Min {
+			stats.Min = p.PriceOre
+		}
+		if p.PriceOre > stats.Max {
+			stats.Max = p.PriceOre
+		}
+	}
+	stats.Avg = sum / len(prices)
+
+	return stats
+}
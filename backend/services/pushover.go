@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 type PushoverService struct {
@@ -49,23 +50,33 @@ func (p *PushoverService) SendNotification(message, title, url string) error {
 		return fmt.Errorf("failed to marshal JSON: %w", err)
 	}
 
+	start := time.Now()
 	resp, err := http.Post(
 		"https://api.pushover.net/1/messages.json",
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
+	observeRequest("pushover", "message", start, resp, err)
 	if err != nil {
+		countPushover("failure")
 		return fmt.Errorf("failed to send notification: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		countPushover("failure")
 		return fmt.Errorf("Pushover API returned status %d", resp.StatusCode)
 	}
 
+	countPushover("success")
 	return nil
 }
 
+// countPushover räknar skickade notiser per utfall
+func countPushover(result string) {
+	Metrics.IncCounter("battery_scheduler_pushover_notifications_total", "Pushover notifications sent, by result.", "result", result)
+}
+
 // SendPriceUpdateNotification skickar notis om nya elpriser
 func (p *PushoverService) SendPriceUpdateNotification(avgPrice int, minPrice int, maxPrice int, appURL string) error {
 	message := fmt.Sprintf(
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"battery-scheduler/db"
+	"battery-scheduler/models"
+)
+
+// HistoryRecorder sparar faktiskt läge, laddnivå, effekt och pris varje kvart,
+// så att planerat och verkligt beteende kan jämföras i efterhand
+type HistoryRecorder struct {
+	db          *db.Database
+	scheduler   *SchedulerService
+	ha          *HomeAssistantService
+	powerEntity string
+}
+
+// NewHistoryRecorder skapar en recorder som läser effekt från powerEntity (W eller kW)
+func NewHistoryRecorder(database *db.Database, scheduler *SchedulerService, ha *HomeAssistantService, powerEntity string) *HistoryRecorder {
+	return &HistoryRecorder{
+		db:          database,
+		scheduler:   scheduler,
+		ha:          ha,
+		powerEntity: powerEntity,
+	}
+}
+
+// Record sparar kvartsvärdet för kvarten som tog slut senast före now. Effekten är tidsviktat
+// medelvärde över kvarten och SoC värdet vid kvartens början, båda ur Home Assistants historik.
+// Värden som inte kan läsas lämnas tomma istället för att hela raden hoppas över.
+func (r *HistoryRecorder) Record(now time.Time) error {
+	quarter := now.Truncate(15 * time.Minute).Add(-15 * time.Minute)
+	end := quarter.Add(15 * time.Minute)
+
+	entry := models.HistoryEntry{
+		Timestamp: quarter,
+		Mode:      r.scheduler.ActiveModeAt(quarter),
+		Event:     "sample",
+	}
+
+	if r.ha.Configured() {
+		if points, err := r.ha.GetHistory(socEntity, quarter, end); err != nil {
+			log.Printf("History: failed to read SoC: %v", err)
+		} else if soc, ok := valueAt(points, quarter); ok {
+			entry.BatterySoC = &soc
+		}
+		if points, err := r.ha.GetHistory(r.powerEntity, quarter, end); err != nil {
+			log.Printf("History: failed to read power: %v", err)
+		} else if powerKW, ok := quarterAverages(points, quarter, end)[quarter.Unix()]; ok {
+			entry.PowerKW = &powerKW
+		} else {
+			log.Printf("History: too little power history for %s", quarter.Format(time.RFC3339))
+		}
+	}
+
+	prices, err := r.db.GetPrices(quarter, end)
+	if err != nil {
+		return fmt.Errorf("failed to read price: %w", err)
+	}
+	if len(prices) > 0 {
+		entry.PriceOre = &prices[0].PriceOre
+	}
+
+	return r.db.SaveHistory(entry)
+}
+
+// valueAt returnerar värdet som gällde vid t, dvs. senaste punkten vid eller före t
+func valueAt(points []HAHistoryPoint, t time.Time) (float64, bool) {
+	value, ok := 0.0, false
+	for _, p := range points {
+		if p.Time.After(t) {
+			break
+		}
+		value, ok = p.Value, true
+	}
+	return value, ok
+}
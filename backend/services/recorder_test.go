@@ -0,0 +1,68 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"battery-scheduler/db"
+	"battery-scheduler/models"
+)
+
+type haHistoryState struct {
+	State       string                 `json:"state"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+	LastChanged time.Time              `json:"last_changed"`
+}
+
+func TestHistoryRecorderAveragesFinishedQuarter(t *testing.T) {
+	quarter := time.Date(2025, 10, 6, 12, 0, 0, 0, time.Local)
+	history := map[string][]haHistoryState{
+		socEntity: {
+			{State: "40", LastChanged: quarter.Add(-time.Hour)},
+			{State: "45", LastChanged: quarter.Add(10 * time.Minute)},
+		},
+		// 1 kW i 5 minuter, sedan 4 kW i 10 minuter: medel 3 kW
+		"sensor.grid_power": {
+			{State: "1000", Attributes: map[string]interface{}{"unit_of_measurement": "W"}, LastChanged: quarter.Add(-time.Hour)},
+			{State: "4000", LastChanged: quarter.Add(5 * time.Minute)},
+		},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([][]haHistoryState{history[r.URL.Query().Get("filter_entity_id")]})
+	}))
+	defer server.Close()
+
+	database, err := db.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+
+	scheduler := NewSchedulerService([]models.ScheduleChange{{Timestamp: quarter, Mode: 2}})
+	recorder := NewHistoryRecorder(database, scheduler, NewHomeAssistantService(server.URL, "token"), "sensor.grid_power")
+	if err := recorder.Record(quarter.Add(15*time.Minute + 3*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := database.GetHistory(quarter.Add(-time.Hour), quarter.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	e := entries[0]
+	if !e.Timestamp.Equal(quarter) || e.Mode != 2 {
+		t.Errorf("entry at %s mode %d, want %s mode 2", e.Timestamp, e.Mode, quarter)
+	}
+	if e.PowerKW == nil || *e.PowerKW < 2.999 || *e.PowerKW > 3.001 {
+		t.Errorf("power = %v, want 3 kW average", e.PowerKW)
+	}
+	if e.BatterySoC == nil || *e.BatterySoC != 40 {
+		t.Errorf("SoC = %v, want 40 at the start of the quarter", e.BatterySoC)
+	}
+}
@@ -2,30 +2,183 @@ package services
 
 import (
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"battery-scheduler/models"
 )
 
+// SchedulerService håller det aktiva schemat i minnet. Säker att använda från flera
+// goroutines: läsare får en ögonblicksbild, och schemat byts alltid ut i sin helhet.
 type SchedulerService struct {
-	schedule []models.ScheduleChange
+	mu       sync.RWMutex
+	schedule []models.ScheduleChange // Sorterat på tid, ändras aldrig på plats
+	override *models.ModeOverride    // Manuellt läge, nil om inget är satt
 }
 
+// maxOverrideDuration är hur länge ett manuellt läge längst får gälla
+const maxOverrideDuration = 48 * time.Hour
+
 // NewSchedulerService skapar en ny scheduler
 func NewSchedulerService(schedule []models.ScheduleChange) *SchedulerService {
 	return &SchedulerService{
-		schedule: schedule,
+		schedule: sortedSchedule(schedule),
 	}
 }
 
 // UpdateSchedule uppdaterar schemat (anropas efter SaveSchedule)
 func (s *SchedulerService) UpdateSchedule(schedule []models.ScheduleChange) {
-	s.schedule = schedule
+	sorted := sortedSchedule(schedule)
+
+	s.mu.Lock()
+	s.schedule = sorted
+	s.mu.Unlock()
+}
+
+// ReplaceSchedule validerar, sparar (via save) och aktiverar ett nytt schema som en enhet.
+// Om save misslyckas behålls det gamla schemat i minnet, och samtidiga anrop serialiseras
+// så att databas och minne aldrig skiljer sig åt.
+func (s *SchedulerService) ReplaceSchedule(schedule []models.ScheduleChange, save func([]models.ScheduleChange) error) error {
+	return s.ModifySchedule(func([]models.ScheduleChange) ([]models.ScheduleChange, error) {
+		return schedule, nil
+	}, save)
 }
 
-// GetCurrentMode returnerar vilket läge som är aktivt just nu
+// ModifySchedule räknar fram ett nytt schema från det aktiva med modify och sparar det som ReplaceSchedule.
+// Hela läs-ändra-skriv-cykeln sker under låset, så samtidiga ändringar går inte förlorade.
+// modify får inte ändra det aktiva schemat på plats.
+func (s *SchedulerService) ModifySchedule(modify func(current []models.ScheduleChange) ([]models.ScheduleChange, error), save func([]models.ScheduleChange) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, err := modify(s.schedule)
+	if err != nil {
+		return err
+	}
+	if err := ValidateSchedule(schedule, s.schedule, time.Now()); err != nil {
+		return err
+	}
+	sorted := sortedSchedule(schedule)
+
+	if err := save(sorted); err != nil {
+		return err
+	}
+	s.schedule = sorted
+	return nil
+}
+
+// Snapshot returnerar en kopia av aktivt schema
+func (s *SchedulerService) Snapshot() []models.ScheduleChange {
+	schedule := s.current()
+	snapshot := make([]models.ScheduleChange, len(schedule))
+	copy(snapshot, schedule)
+	return snapshot
+}
+
+// current returnerar aktuell slice. Den ändras aldrig på plats och kan läsas utan lås.
+func (s *SchedulerService) current() []models.ScheduleChange {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.schedule
+}
+
+func sortedSchedule(schedule []models.ScheduleChange) []models.ScheduleChange {
+	sorted := make([]models.ScheduleChange, len(schedule))
+	copy(sorted, schedule)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+	return sorted
+}
+
+// ValidateOverride kontrollerar ett manuellt läge
+func ValidateOverride(o models.ModeOverride, now time.Time) error {
+	if _, ok := models.ModeDescriptions[o.Mode]; !ok {
+		return fmt.Errorf("ogiltigt läge %d", o.Mode)
+	}
+	if !o.Expires.After(o.Start) {
+		return fmt.Errorf("överstyrningen måste sluta efter att den börjar")
+	}
+	if !o.Expires.After(now) {
+		return fmt.Errorf("överstyrningen har redan gått ut")
+	}
+	if o.Expires.Sub(o.Start) > maxOverrideDuration {
+		return fmt.Errorf("överstyrningen får gälla högst %.0f timmar", maxOverrideDuration.Hours())
+	}
+	return nil
+}
+
+// SetOverride sätter ett manuellt läge som gäller före schemat mellan Start och Expires.
+// Ett tidigare manuellt läge ersätts.
+func (s *SchedulerService) SetOverride(o models.ModeOverride) {
+	s.mu.Lock()
+	s.override = &o
+	s.mu.Unlock()
+}
+
+// ClearOverride tar bort manuellt läge. Returnerar false om inget aktivt eller kommande fanns.
+func (s *SchedulerService) ClearOverride(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	had := s.override != nil && now.Before(s.override.Expires)
+	s.override = nil
+	return had
+}
+
+// Override returnerar aktivt eller kommande manuellt läge, nil om inget finns eller det har gått ut
+func (s *SchedulerService) Override(now time.Time) *models.ModeOverride {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.override == nil || !now.Before(s.override.Expires) {
+		return nil
+	}
+	o := *s.override
+	return &o
+}
+
+// GetCurrentMode returnerar vilket läge som är aktivt just nu. Ett manuellt läge går före schemat,
+// och när det går ut gäller schemat igen.
 func (s *SchedulerService) GetCurrentMode(now time.Time) models.CurrentModeResponse {
-	if len(s.schedule) == 0 {
+	response := s.scheduledMode(now)
+
+	o := s.Override(now)
+	if o == nil {
+		return response
+	}
+	if now.Before(o.Start) {
+		// Överstyrningen har inte börjat och är nästa ändring om schemat inte ändras före
+		if response.NextChange.IsZero() || o.Start.Before(response.NextChange) {
+			response.NextChange = o.Start
+			response.NextMode = o.Mode
+		}
+		return response
+	}
+
+	return models.CurrentModeResponse{
+		Mode:        o.Mode,
+		Timestamp:   now,
+		NextChange:  o.Expires,
+		NextMode:    s.GetModeForTime(o.Expires),
+		Description: models.ModeDescriptions[o.Mode],
+		Override:    o,
+	}
+}
+
+// ActiveModeAt returnerar läget som gäller vid t, med manuellt läge inräknat
+func (s *SchedulerService) ActiveModeAt(t time.Time) int {
+	if o := s.Override(t); o != nil && !t.Before(o.Start) {
+		return o.Mode
+	}
+	return s.GetModeForTime(t)
+}
+
+// scheduledMode returnerar läget enligt schemat vid now och nästa brytpunkt
+func (s *SchedulerService) scheduledMode(now time.Time) models.CurrentModeResponse {
+	schedule := s.current()
+	if len(schedule) == 0 {
 		// Inget schema - default är Passiv (läge 1)
 		return models.CurrentModeResponse{
 			Mode:        1,
@@ -34,26 +187,19 @@ func (s *SchedulerService) GetCurrentMode(now time.Time) models.CurrentModeRespo
 		}
 	}
 
-	// Hitta senaste breakpoint före eller vid "now"
+	// Hitta senaste breakpoint före eller vid "now" (binärsökning, schemat är sorterat)
 	var currentMode int = 1 // Default
 	var nextChange time.Time
 	var nextMode int
 
-	for i, change := range s.schedule {
-		if change.Timestamp.After(now) {
-			// Detta är nästa ändring
-			nextChange = change.Timestamp
-			nextMode = change.Mode
-			break
-		}
-		// Detta är den senaste breakpoint som passerat
-		currentMode = change.Mode
-
-		// Kolla om det finns en nästa ändring
-		if i+1 < len(s.schedule) {
-			nextChange = s.schedule[i+1].Timestamp
-			nextMode = s.schedule[i+1].Mode
-		}
+	i := lastAtOrBefore(schedule, now)
+	if i >= 0 {
+		currentMode = schedule[i].Mode
+	}
+	if i+1 < len(schedule) {
+		// Detta är nästa ändring
+		nextChange = schedule[i+1].Timestamp
+		nextMode = schedule[i+1].Mode
 	}
 
 	response := models.CurrentModeResponse{
@@ -71,56 +217,7 @@ func (s *SchedulerService) GetCurrentMode(now time.Time) models.CurrentModeRespo
 	return response
 }
 
-// GetModeForTime returnerar vilket läge som gäller vid en specifik tidpunkt
+// GetModeForTime returnerar vilket läge som gäller enligt schemat vid en specifik tidpunkt
 func (s *SchedulerService) GetModeForTime(t time.Time) int {
-	if len(s.schedule) == 0 {
-		return 1 // Default Passiv
-	}
-
-	currentMode := 1
-	for _, change := range s.schedule {
-		if change.Timestamp.After(t) {
-			break
-		}
-		currentMode = change.Mode
-	}
-
-	return currentMode
-}
-
-// ValidateSchedule kontrollerar att schemat är giltigt
-func (s *SchedulerService) ValidateSchedule(schedule []models.ScheduleChange) error {
-	// Kontrollera att laddboxar inte överlappar
-	chargerActive := make(map[time.Time]int) // timestamp -> mode (5 eller 6)
-
-	for _, change := range schedule {
-		if change.Mode == 5 || change.Mode == 6 {
-			// Hitta när denna laddbox slutar (nästa breakpoint)
-			var endTime time.Time
-			for _, nextChange := range schedule {
-				if nextChange.Timestamp.After(change.Timestamp) {
-					endTime = nextChange.Timestamp
-					break
-				}
-			}
-
-			// Om ingen slutpunkt finns, fortsätter den till slutet
-			if endTime.IsZero() {
-				endTime = change.Timestamp.Add(48 * time.Hour) // Arbiträrt långt
-			}
-
-			// Kontrollera överlappning med andra laddboxar
-			for checkTime := change.Timestamp; checkTime.Before(endTime); checkTime = checkTime.Add(15 * time.Minute) {
-				if existingMode, exists := chargerActive[checkTime]; exists {
-					if existingMode == 5 || existingMode == 6 {
-						// Det finns redan en laddbox aktiv vid denna tid
-						return fmt.Errorf("laddboxar överlappar vid %s", checkTime.Format("2006-01-02 15:04"))
-					}
-				}
-				chargerActive[checkTime] = change.Mode
-			}
-		}
-	}
-
-	return nil
+	return ModeAt(s.current(), t)
 }
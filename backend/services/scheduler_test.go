@@ -0,0 +1,159 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+// futureQuarter returnerar en hel kvart n kvartar framåt, så att past_edit inte slår till
+func futureQuarter(n int) time.Time {
+	return time.Now().Truncate(15 * time.Minute).Add(time.Duration(n) * 15 * time.Minute)
+}
+
+func TestSchedulerConcurrentReadsAndSwaps(t *testing.T) {
+	scheduler := NewSchedulerService(nil)
+	noSave := func([]models.ScheduleChange) error { return nil }
+
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				now := time.Now()
+				current := scheduler.GetCurrentMode(now)
+				if _, ok := models.ModeDescriptions[current.Mode]; !ok {
+					t.Errorf("GetCurrentMode returned unknown mode %d", current.Mode)
+					return
+				}
+				scheduler.GetModeForTime(now.Add(time.Hour))
+				scheduler.Snapshot()
+			}
+		}()
+	}
+
+	var writers sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for i := 0; i < 200; i++ {
+				mode := 1 + (w+i)%3
+				if i%2 == 0 {
+					schedule := []models.ScheduleChange{
+						{Timestamp: futureQuarter(4), Mode: mode},
+						{Timestamp: futureQuarter(8), Mode: 1},
+					}
+					if err := scheduler.ReplaceSchedule(schedule, noSave); err != nil {
+						t.Errorf("ReplaceSchedule: %v", err)
+						return
+					}
+					continue
+				}
+				err := scheduler.ModifySchedule(func(current []models.ScheduleChange) ([]models.ScheduleChange, error) {
+					return ApplyScheduleOperation(current, ScheduleOperation{
+						Op: ScheduleOpSet, From: futureQuarter(12), To: futureQuarter(16), Mode: mode,
+					})
+				}, noSave)
+				if err != nil {
+					t.Errorf("ModifySchedule: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+}
+
+func TestSchedulerModifyIsSerialized(t *testing.T) {
+	scheduler := NewSchedulerService(nil)
+	noSave := func([]models.ScheduleChange) error { return nil }
+
+	// Varje skrivning lägger till en brytpunkt; försvinner någon har en läs-ändra-skriv-cykel tappats
+	const writes = 50
+	var wg sync.WaitGroup
+	for i := 0; i < writes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := scheduler.ModifySchedule(func(current []models.ScheduleChange) ([]models.ScheduleChange, error) {
+				return append(append([]models.ScheduleChange{}, current...), models.ScheduleChange{
+					Timestamp: futureQuarter(4 + i), Mode: 2 + i%2,
+				}), nil
+			}, noSave)
+			if err != nil {
+				t.Errorf("ModifySchedule: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := len(scheduler.Snapshot()); got != writes {
+		t.Fatalf("got %d breakpoints, want %d", got, writes)
+	}
+}
+
+func TestSchedulerFailedSaveKeepsSchedule(t *testing.T) {
+	original := []models.ScheduleChange{{Timestamp: futureQuarter(4), Mode: 2}}
+	scheduler := NewSchedulerService(original)
+	saveErr := errors.New("disk full")
+
+	replacement := []models.ScheduleChange{{Timestamp: futureQuarter(4), Mode: 3}}
+	err := scheduler.ReplaceSchedule(replacement, func(sorted []models.ScheduleChange) error {
+		if len(sorted) != 1 || sorted[0].Mode != 3 {
+			t.Errorf("save got %v, want the new schedule", sorted)
+		}
+		return saveErr
+	})
+	if !errors.Is(err, saveErr) {
+		t.Fatalf("ReplaceSchedule error = %v, want %v", err, saveErr)
+	}
+
+	err = scheduler.ModifySchedule(func(current []models.ScheduleChange) ([]models.ScheduleChange, error) {
+		return nil, nil
+	}, func([]models.ScheduleChange) error { return saveErr })
+	if !errors.Is(err, saveErr) {
+		t.Fatalf("ModifySchedule error = %v, want %v", err, saveErr)
+	}
+
+	snapshot := scheduler.Snapshot()
+	if len(snapshot) != 1 || snapshot[0].Mode != 2 {
+		t.Fatalf("schedule after failed saves = %v, want %v", snapshot, original)
+	}
+	if mode := scheduler.GetModeForTime(futureQuarter(5)); mode != 2 {
+		t.Fatalf("mode after failed save = %d, want 2", mode)
+	}
+}
+
+func TestSchedulerInvalidScheduleIsNotSaved(t *testing.T) {
+	scheduler := NewSchedulerService(nil)
+	saved := false
+
+	err := scheduler.ReplaceSchedule([]models.ScheduleChange{{Timestamp: futureQuarter(4), Mode: 9}}, func([]models.ScheduleChange) error {
+		saved = true
+		return nil
+	})
+	var validationErr *ScheduleValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("error = %v, want *ScheduleValidationError", err)
+	}
+	if saved {
+		t.Fatal("save was called for an invalid schedule")
+	}
+	if len(scheduler.Snapshot()) != 0 {
+		t.Fatal("invalid schedule became active")
+	}
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"battery-scheduler/models"
+)
+
+// ChargeCurvePoint är en punkt på laddkurvan: laddeffekt (kW) vid en viss laddnivå (%)
+type ChargeCurvePoint struct {
+	SoC     float64 `json:"soc"`
+	PowerKW float64 `json:"power_kw"`
+}
+
+// DefaultChargeCurve är laddkurvan som tidigare låg i frontendens getChargePower.
+// Under första punkten laddar batteriet inte alls.
+var DefaultChargeCurve = []ChargeCurvePoint{
+	{SoC: 15, PowerKW: 10},
+	{SoC: 80, PowerKW: 10},
+	{SoC: 90, PowerKW: 5},
+	{SoC: 95, PowerKW: 2},
+	{SoC: 100, PowerKW: 2},
+}
+
+// BatterySimulator simulerar batteriets laddnivå kvart för kvart.
+// Samma modell används av /api/simulation och av optimeraren.
+type BatterySimulator struct {
+	CapacityKWh float64
+	MinSoC      float64
+	Efficiency  float64 // Verkningsgrad vid laddning (0-1], påverkar bara energin som köps
+	ChargeCurve []ChargeCurvePoint
+}
+
+// NewBatterySimulator skapar en simulator med standardladdkurvan
+func NewBatterySimulator(capacityKWh, minSoC float64) *BatterySimulator {
+	return &BatterySimulator{
+		CapacityKWh: capacityKWh,
+		MinSoC:      minSoC,
+		Efficiency:  1,
+		ChargeCurve: DefaultChargeCurve,
+	}
+}
+
+// ParseChargeCurve tolkar en laddkurva på formatet "15:10,80:10,90:5" (SoC:kW)
+func ParseChargeCurve(value string) ([]ChargeCurvePoint, error) {
+	var curve []ChargeCurvePoint
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.SplitN(part, ":", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("ogiltig punkt i laddkurva: %q", part)
+		}
+		soc, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("ogiltig SoC i laddkurva: %q", part)
+		}
+		power, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("ogiltig effekt i laddkurva: %q", part)
+		}
+		curve = append(curve, ChargeCurvePoint{SoC: soc, PowerKW: power})
+	}
+	if len(curve) == 0 {
+		return nil, fmt.Errorf("laddkurvan är tom")
+	}
+
+	sort.Slice(curve, func(i, j int) bool {
+		return curve[i].SoC < curve[j].SoC
+	})
+
+	return curve, nil
+}
+
+// ChargePowerKW returnerar laddeffekten (kW) vid en given laddnivå genom linjär interpolering i laddkurvan
+func (b *BatterySimulator) ChargePowerKW(soc float64) float64 {
+	curve := b.ChargeCurve
+	if len(curve) == 0 || soc < curve[0].SoC {
+		return 0
+	}
+	for i := 1; i < len(curve); i++ {
+		if soc <= curve[i].SoC {
+			prev := curve[i-1]
+			span := curve[i].SoC - prev.SoC
+			if span == 0 {
+				return curve[i].PowerKW
+			}
+			return prev.PowerKW + (soc-prev.SoC)/span*(curve[i].PowerKW-prev.PowerKW)
+		}
+	}
+	return curve[len(curve)-1].PowerKW
+}
+
+// Step simulerar en kvart i ett givet läge. loadKW är nettolasten (förbrukning minus solel)
+// och är negativ vid solöverskott. Returnerar laddnivån efter kvarten och energin (kWh) som
+// köps från elnätet under kvarten. Överskott som inte ryms i batteriet exporteras och räknas inte.
+func (b *BatterySimulator) Step(soc float64, mode int, loadKW float64) (float64, float64) {
+	const quarterHours = 0.25
+	loadKWh := loadKW * quarterHours
+	efficiency := b.Efficiency
+	if efficiency <= 0 || efficiency > 1 {
+		efficiency = 1
+	}
+
+	switch mode {
+	case modeCharge:
+		storedKWh := b.ChargePowerKW(soc) * quarterHours
+		next := math.Min(100, soc+storedKWh/b.CapacityKWh*100)
+		actualKWh := (next - soc) / 100 * b.CapacityKWh
+		return next, math.Max(0, loadKWh+actualKWh/efficiency)
+
+	case modeDischarge:
+		if soc <= b.MinSoC || loadKWh <= 0 {
+			return soc, math.Max(0, loadKWh)
+		}
+		availableKWh := (soc - b.MinSoC) / 100 * b.CapacityKWh
+		deliveredKWh := math.Min(loadKWh, availableKWh)
+		return soc - deliveredKWh/b.CapacityKWh*100, loadKWh - deliveredKWh
+	}
+
+	// Passiv, effektbegränsning och laddbox: batteriet laddas bara av solöverskott
+	if loadKWh < 0 {
+		surplusKWh := math.Min(-loadKWh*efficiency, b.ChargePowerKW(soc)*quarterHours)
+		next := math.Min(100, soc+surplusKWh/b.CapacityKWh*100)
+		return next, 0
+	}
+	return soc, loadKWh
+}
+
+// Simulate räknar fram laddnivån för varje kvart i estimates givet ett schema.
+// SoC i varje punkt är nivån vid kvartens början; första punkten har startSoC.
+func (b *BatterySimulator) Simulate(schedule []models.ScheduleChange, estimates []models.PowerEstimate, startSoC float64) []models.SimulationPoint {
+	sorted := make([]models.ScheduleChange, len(schedule))
+	copy(sorted, schedule)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	points := make([]models.SimulationPoint, 0, len(estimates))
+	soc := startSoC
+	for _, e := range estimates {
+		mode := ModeAt(sorted, e.Timestamp)
+		next, gridKWh := b.Step(soc, mode, e.PowerKW-e.SolarKW)
+
+		points = append(points, models.SimulationPoint{
+			Timestamp: e.Timestamp,
+			Mode:      mode,
+			SoC:       math.Round(soc*10) / 10,
+			PowerKW:   e.PowerKW,
+			SolarKW:   e.SolarKW,
+			GridKWh:   math.Round(gridKWh*1000) / 1000,
+		})
+		soc = next
+	}
+
+	return points
+}
@@ -5,18 +5,40 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"battery-scheduler/models"
+)
+
+// DefaultSMHILat och DefaultSMHILon är prognospunkten om ingen plats är inställd
+const (
+	DefaultSMHILat = 59.38309
+	DefaultSMHILon = 17.01550
 )
 
-// SMHIService hämtar väderprognos från SMHI
+// smhiCoverage är hörnen (lon, lat) i SMHI:s prognosområde för punktprognoser
+var smhiCoverage = [][2]float64{
+	{2.250475, 52.500440},
+	{27.392184, 52.542473},
+	{37.934697, 70.742227},
+	{-8.236963, 70.596319},
+}
+
+// SMHIService hämtar väderprognos (temperatur, molnighet och instrålning) från SMHI.
+// Platsen kan bytas medan tjänsten används.
 type SMHIService struct {
+	mu  sync.RWMutex
 	lat float64
 	lon float64
 }
 
 // SMHIResponse representerar svaret från SMHI API
 type SMHIResponse struct {
-	TimeSeries []SMHITimeSeries `json:"timeSeries"`
+	ApprovedTime string           `json:"approvedTime"` // När prognosen gavs ut
+	TimeSeries   []SMHITimeSeries `json:"timeSeries"`
 }
 
 type SMHITimeSeries struct {
@@ -25,13 +47,9 @@ type SMHITimeSeries struct {
 }
 
 type SMHIDataItem struct {
-	AirTemperature float64 `json:"air_temperature"`
-}
-
-// TemperatureForecast är en temperaturprognos för en tidpunkt
-type TemperatureForecast struct {
-	Time        time.Time `json:"time"`
-	Temperature float64   `json:"temperature"`
+	AirTemperature    float64  `json:"air_temperature"`
+	CloudAreaFraction *float64 `json:"cloud_area_fraction"`                       // Total molnighet i oktas (0-8)
+	GlobalRadiation   *float64 `json:"surface_downwelling_shortwave_flux_in_air"` // Global instrålning W/m², saknas oftast
 }
 
 // NewSMHIService skapar en ny SMHI-tjänst
@@ -39,85 +57,186 @@ func NewSMHIService(lat, lon float64) *SMHIService {
 	return &SMHIService{lat: lat, lon: lon}
 }
 
-// FetchForecast hämtar temperaturprognos från SMHI
-func (s *SMHIService) FetchForecast() ([]TemperatureForecast, error) {
+// Location returnerar prognospunktens koordinater
+func (s *SMHIService) Location() (float64, float64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lat, s.lon
+}
+
+// SetLocation byter prognospunkt. Koordinaterna måste ligga inom SMHI:s prognosområde.
+func (s *SMHIService) SetLocation(lat, lon float64) error {
+	if err := ValidateSMHILocation(lat, lon); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.lat, s.lon = lat, lon
+	s.mu.Unlock()
+	return nil
+}
+
+// ParseSMHILocation tolkar latitud och longitud (decimalgrader) och kontrollerar att de täcks av SMHI
+func ParseSMHILocation(latValue, lonValue string) (float64, float64, error) {
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latValue), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ogiltig latitud %q", latValue)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(lonValue), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ogiltig longitud %q", lonValue)
+	}
+	if err := ValidateSMHILocation(lat, lon); err != nil {
+		return 0, 0, err
+	}
+	return lat, lon, nil
+}
+
+// ValidateSMHILocation kontrollerar att en punkt ligger inom SMHI:s prognosområde
+func ValidateSMHILocation(lat, lon float64) error {
+	if !pointInPolygon(lon, lat, smhiCoverage) {
+		return fmt.Errorf("platsen %.5f, %.5f ligger utanför SMHI:s prognosområde", lat, lon)
+	}
+	return nil
+}
+
+// pointInPolygon avgör med strålkastning om (x, y) ligger inom polygonen
+func pointInPolygon(x, y float64, polygon [][2]float64) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		xi, yi := polygon[i][0], polygon[i][1]
+		xj, yj := polygon[j][0], polygon[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// FetchForecast hämtar temperatur-, moln- och instrålningsprognos från SMHI.
+// Returnerar även prognosens utgivningstid.
+func (s *SMHIService) FetchForecast() ([]models.ForecastPoint, time.Time, error) {
+	lat, lon := s.Location()
 	url := fmt.Sprintf(
 		"https://opendata-download-metfcst.smhi.se/api/category/snow1g/version/1/geotype/point/lon/%.5f/lat/%.5f/data.json",
-		s.lon, s.lat,
+		lon, lat,
 	)
 
+	start := time.Now()
 	resp, err := http.Get(url)
+	observeRequest("smhi", "forecast", start, resp, err)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch SMHI forecast: %w", err)
+		return nil, time.Time{}, fmt.Errorf("failed to fetch SMHI forecast: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("SMHI API returned status %d", resp.StatusCode)
+		return nil, time.Time{}, fmt.Errorf("SMHI API returned status %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read SMHI response: %w", err)
+		return nil, time.Time{}, fmt.Errorf("failed to read SMHI response: %w", err)
 	}
 
 	var smhiResp SMHIResponse
 	if err := json.Unmarshal(body, &smhiResp); err != nil {
-		return nil, fmt.Errorf("failed to parse SMHI response: %w", err)
+		return nil, time.Time{}, fmt.Errorf("failed to parse SMHI response: %w", err)
 	}
 
-	var forecasts []TemperatureForecast
+	var forecasts []models.ForecastPoint
 	for _, ts := range smhiResp.TimeSeries {
 		t, err := time.Parse(time.RFC3339, ts.Time)
 		if err != nil {
 			continue
 		}
-		forecasts = append(forecasts, TemperatureForecast{
-			Time:        t,
-			Temperature: ts.Data.AirTemperature,
+		cloudCover := ts.Data.CloudAreaFraction
+		if cloudCover != nil && *cloudCover > 8 {
+			octas := *cloudCover / 100 * 8 // Angiven i procent
+			cloudCover = &octas
+		}
+		forecasts = append(forecasts, models.ForecastPoint{
+			Time:            t,
+			Temperature:     ts.Data.AirTemperature,
+			CloudCover:      cloudCover,
+			GlobalRadiation: ts.Data.GlobalRadiation,
 		})
 	}
 
-	return forecasts, nil
+	approved, err := time.Parse(time.RFC3339, smhiResp.ApprovedTime)
+	if err != nil {
+		approved = time.Now() // Saknas i svaret, räkna prognosen som ny
+	}
+
+	return forecasts, approved, nil
 }
 
 // GetTemperatureAt returnerar interpolerad temperatur vid en given tidpunkt
-func (s *SMHIService) GetTemperatureAt(forecasts []TemperatureForecast, t time.Time) float64 {
-	if len(forecasts) == 0 {
+func (s *SMHIService) GetTemperatureAt(forecasts []models.ForecastPoint, t time.Time) float64 {
+	temp, ok := interpolateForecast(forecasts, t, func(f models.ForecastPoint) (float64, bool) {
+		return f.Temperature, true
+	})
+	if !ok {
 		return 5.0 // Fallback
 	}
+	return temp
+}
 
-	// Hitta närmaste prognosvärden före och efter t
-	var before, after *TemperatureForecast
+// GetCloudCoverAt returnerar interpolerad molnighet (oktas) vid en given tidpunkt
+func (s *SMHIService) GetCloudCoverAt(forecasts []models.ForecastPoint, t time.Time) (float64, bool) {
+	return interpolateForecast(forecasts, t, func(f models.ForecastPoint) (float64, bool) {
+		if f.CloudCover == nil {
+			return 0, false
+		}
+		return *f.CloudCover, true
+	})
+}
+
+// GetGlobalRadiationAt returnerar interpolerad global instrålning (W/m²) om prognosen har den
+func (s *SMHIService) GetGlobalRadiationAt(forecasts []models.ForecastPoint, t time.Time) (float64, bool) {
+	return interpolateForecast(forecasts, t, func(f models.ForecastPoint) (float64, bool) {
+		if f.GlobalRadiation == nil {
+			return 0, false
+		}
+		return *f.GlobalRadiation, true
+	})
+}
+
+// interpolateForecast interpolerar linjärt mellan närmaste prognosvärden före och efter t.
+// Prognoser där value saknas hoppas över.
+func interpolateForecast(forecasts []models.ForecastPoint, t time.Time, value func(models.ForecastPoint) (float64, bool)) (float64, bool) {
+	var before, after *models.ForecastPoint
+	var beforeValue, afterValue float64
 	for i := range forecasts {
-		if !forecasts[i].Time.After(t) {
-			before = &forecasts[i]
+		v, ok := value(forecasts[i])
+		if !ok {
+			continue
 		}
-		if forecasts[i].Time.After(t) {
-			after = &forecasts[i]
-			break
+		if !forecasts[i].Time.After(t) {
+			before, beforeValue = &forecasts[i], v
+			continue
 		}
+		after, afterValue = &forecasts[i], v
+		break
 	}
 
 	if before == nil && after == nil {
-		return 5.0
+		return 0, false
 	}
 	if before == nil {
-		return after.Temperature
+		return afterValue, true
 	}
 	if after == nil {
-		return before.Temperature
+		return beforeValue, true
 	}
 
 	// Linjär interpolering
 	totalDuration := after.Time.Sub(before.Time).Seconds()
 	if totalDuration == 0 {
-		return before.Temperature
+		return beforeValue, true
 	}
-	elapsed := t.Sub(before.Time).Seconds()
-	ratio := elapsed / totalDuration
+	ratio := t.Sub(before.Time).Seconds() / totalDuration
 
-	return before.Temperature + ratio*(after.Temperature-before.Temperature)
+	return beforeValue + ratio*(afterValue-beforeValue), true
 }
 
 // ConsumptionFromTemperature beräknar prognosticerad förbrukning (kW) baserat på temperatur
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// DefaultCloudCover används när prognosen saknar molnighet (oktas, halvklart)
+const DefaultCloudCover = 4.0
+
+// PVSystem beskriver solcellsanläggningen
+type PVSystem struct {
+	KWp              float64 `json:"kwp"`               // Installerad toppeffekt, 0 = inga solceller
+	TiltDeg          float64 `json:"tilt_deg"`          // Panelernas lutning från horisontalplanet
+	AzimuthDeg       float64 `json:"azimuth_deg"`       // Riktning, 0 = norr, 90 = öster, 180 = söder, 270 = väster
+	PerformanceRatio float64 `json:"performance_ratio"` // Förluster i växelriktare, kablar, värme m.m. (0-1]
+	Lat              float64 `json:"lat"`
+	Lon              float64 `json:"lon"`
+}
+
+// DefaultPVSystem är en vanlig villaanläggning, söderläge och 35° lutning
+var DefaultPVSystem = PVSystem{
+	TiltDeg:          35,
+	AzimuthDeg:       180,
+	PerformanceRatio: 0.85,
+}
+
+// ValidatePVSystem kontrollerar att anläggningens parametrar är rimliga
+func ValidatePVSystem(p PVSystem) error {
+	if p.KWp < 0 {
+		return fmt.Errorf("pv_kwp får inte vara negativ")
+	}
+	if p.TiltDeg < 0 || p.TiltDeg > 90 {
+		return fmt.Errorf("ogiltig lutning %.0f° (0-90)", p.TiltDeg)
+	}
+	if p.AzimuthDeg < 0 || p.AzimuthDeg >= 360 {
+		return fmt.Errorf("ogiltig riktning %.0f° (0-359)", p.AzimuthDeg)
+	}
+	if p.PerformanceRatio <= 0 || p.PerformanceRatio > 1 {
+		return fmt.Errorf("ogiltig verkningsgrad %.2f (0-1]", p.PerformanceRatio)
+	}
+	return nil
+}
+
+// QuarterProductionKW returnerar medeleffekten (kW) under kvarten som börjar vid start.
+// Solens läge räknas i mitten av kvarten. globalRadiation (W/m²) används om den finns,
+// annars en klarvädersmodell dämpad med molnigheten.
+func (p PVSystem) QuarterProductionKW(start time.Time, cloudOctas float64, globalRadiation *float64) float64 {
+	if p.KWp <= 0 {
+		return 0
+	}
+
+	elevation, azimuth := SunPosition(start.Add(7*time.Minute+30*time.Second), p.Lat, p.Lon)
+	if elevation <= 0 {
+		return 0
+	}
+	cosZenith := math.Sin(deg2rad(elevation))
+
+	var ghi float64
+	if globalRadiation != nil {
+		ghi = math.Max(0, *globalRadiation)
+	} else {
+		// Haurwitz klarvädersmodell, dämpad enligt Kasten-Czeplak
+		clearSky := 1098 * cosZenith * math.Exp(-0.057/cosZenith)
+		clouds := math.Min(8, math.Max(0, cloudOctas)) / 8
+		ghi = clearSky * (1 - 0.75*math.Pow(clouds, 3.4))
+	}
+
+	poa := planeOfArrayIrradiance(ghi, cosZenith, elevation, azimuth, p.TiltDeg, p.AzimuthDeg)
+	return math.Min(p.KWp, p.KWp*poa/1000*p.PerformanceRatio)
+}
+
+// planeOfArrayIrradiance räknar om global instrålning till panelernas plan. Direkt och diffus
+// strålning delas upp med Erbs samband, och diffus och markreflekterad strålning antas isotropa.
+func planeOfArrayIrradiance(ghi, cosZenith, sunElevation, sunAzimuth, tiltDeg, panelAzimuthDeg float64) float64 {
+	const solarConstant = 1367.0
+	const albedo = 0.2
+
+	kt := math.Min(1, ghi/(solarConstant*cosZenith))
+	var diffuseFraction float64
+	switch {
+	case kt <= 0.22:
+		diffuseFraction = 1 - 0.09*kt
+	case kt <= 0.8:
+		diffuseFraction = 0.9511 - 0.1604*kt + 4.388*kt*kt - 16.638*math.Pow(kt, 3) + 12.336*math.Pow(kt, 4)
+	default:
+		diffuseFraction = 0.165
+	}
+	dhi := ghi * diffuseFraction
+	dni := (ghi - dhi) / cosZenith
+
+	tilt := deg2rad(tiltDeg)
+	cosIncidence := math.Sin(deg2rad(sunElevation))*math.Cos(tilt) +
+		math.Cos(deg2rad(sunElevation))*math.Sin(tilt)*math.Cos(deg2rad(sunAzimuth-panelAzimuthDeg))
+
+	direct := dni * math.Max(0, cosIncidence)
+	diffuse := dhi * (1 + math.Cos(tilt)) / 2
+	reflected := ghi * albedo * (1 - math.Cos(tilt)) / 2
+
+	return direct + diffuse + reflected
+}
+
+// SunPosition returnerar solens höjd och azimut (grader, 0 = norr, medurs) vid en tidpunkt.
+// Förenklad astronomisk modell, noggrann till några tiondels grad.
+func SunPosition(t time.Time, lat, lon float64) (float64, float64) {
+	j2000 := time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := t.UTC().Sub(j2000).Hours() / 24
+
+	meanAnomaly := deg2rad(357.529 + 0.98560028*d)
+	meanLongitude := 280.459 + 0.98564736*d
+	eclipticLongitude := deg2rad(meanLongitude + 1.915*math.Sin(meanAnomaly) + 0.020*math.Sin(2*meanAnomaly))
+	obliquity := deg2rad(23.439 - 0.00000036*d)
+
+	rightAscension := math.Atan2(math.Cos(obliquity)*math.Sin(eclipticLongitude), math.Cos(eclipticLongitude))
+	declination := math.Asin(math.Sin(obliquity) * math.Sin(eclipticLongitude))
+
+	siderealDeg := math.Mod(280.46061837+360.98564736629*d+lon, 360)
+	hourAngle := deg2rad(siderealDeg) - rightAscension
+
+	phi := deg2rad(lat)
+	elevation := math.Asin(math.Sin(phi)*math.Sin(declination) + math.Cos(phi)*math.Cos(declination)*math.Cos(hourAngle))
+	azimuth := math.Atan2(-math.Sin(hourAngle), math.Tan(declination)*math.Cos(phi)-math.Sin(phi)*math.Cos(hourAngle))
+
+	azimuthDeg := math.Mod(rad2deg(azimuth)+360, 360)
+	return rad2deg(elevation), azimuthDeg
+}
+
+func deg2rad(deg float64) float64 { return deg * math.Pi / 180 }
+
+func rad2deg(rad float64) float64 { return rad * 180 / math.Pi }
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+// Tillåtna tariffkomponentslag
+var tariffKinds = map[string]bool{
+	"energy_tax": true, // Energiskatt
+	"grid_fee":   true, // Nätägarens överföringsavgift
+	"markup":     true, // Elhandlarens påslag
+}
+
+// ValidateTariffComponent kontrollerar att en tariffkomponent är komplett och konsekvent
+func ValidateTariffComponent(c models.TariffComponent) error {
+	if c.Name == "" {
+		return fmt.Errorf("namn saknas")
+	}
+	if !tariffKinds[c.Kind] {
+		return fmt.Errorf("ogiltigt slag %q (energy_tax, grid_fee eller markup)", c.Kind)
+	}
+
+	switch c.Type {
+	case models.TariffFlat:
+	case models.TariffTimeOfUse:
+		if c.StartHour == nil || c.EndHour == nil {
+			return fmt.Errorf("time_of_use kräver start_hour och end_hour")
+		}
+	case models.TariffSeasonal:
+		if len(c.Months) == 0 {
+			return fmt.Errorf("seasonal kräver months")
+		}
+	default:
+		return fmt.Errorf("ogiltig typ %q (flat, time_of_use eller seasonal)", c.Type)
+	}
+
+	if (c.StartHour == nil) != (c.EndHour == nil) {
+		return fmt.Errorf("start_hour och end_hour måste anges tillsammans")
+	}
+	if c.StartHour != nil && (*c.StartHour < 0 || *c.StartHour > 23 || *c.EndHour < 1 || *c.EndHour > 24) {
+		return fmt.Errorf("ogiltigt timintervall %d-%d", *c.StartHour, *c.EndHour)
+	}
+	for _, d := range c.Weekdays {
+		if d < 1 || d > 7 {
+			return fmt.Errorf("ogiltig veckodag %d (1=måndag ... 7=söndag)", d)
+		}
+	}
+	for _, m := range c.Months {
+		if m < 1 || m > 12 {
+			return fmt.Errorf("ogiltig månad %d", m)
+		}
+	}
+
+	return nil
+}
+
+// tariffApplies avgör om en komponent gäller vid en tidpunkt (lokal tid)
+func tariffApplies(c models.TariffComponent, t time.Time) bool {
+	if !c.Enabled {
+		return false
+	}
+	if c.Type == models.TariffFlat {
+		return true
+	}
+
+	t = t.In(time.Local)
+
+	if len(c.Months) > 0 && !containsInt(c.Months, int(t.Month())) {
+		return false
+	}
+	if len(c.Weekdays) > 0 && !containsInt(c.Weekdays, isoWeekday(t)) {
+		return false
+	}
+	if c.StartHour != nil && c.EndHour != nil {
+		hour := t.Hour()
+		start, end := *c.StartHour, *c.EndHour
+		if start < end {
+			if hour < start || hour >= end {
+				return false
+			}
+		} else if hour < start && hour >= end {
+			// Intervallet går över midnatt, t.ex. 22-6
+			return false
+		}
+	}
+
+	return true
+}
+
+// TariffOreAt returnerar summan av alla tariffkomponenter (öre/kWh exkl moms) som gäller vid t
+func TariffOreAt(components []models.TariffComponent, t time.Time) float64 {
+	var sum float64
+	for _, c := range components {
+		if tariffApplies(c, t) {
+			sum += c.OrePerKWh
+		}
+	}
+	return sum
+}
+
+// ApplyTariff sätter TotalOre (spotpris inkl moms + tariffkomponenter inkl moms) på varje pris
+func ApplyTariff(prices []models.Price, components []models.TariffComponent) []models.Price {
+	const VAT = 1.25 // 25% moms
+
+	result := make([]models.Price, len(prices))
+	for i, p := range prices {
+		total := p.PriceOre + int(math.Round(TariffOreAt(components, p.Timestamp)*VAT))
+		p.TotalOre = &total
+		result[i] = p
+	}
+	return result
+}
+
+// effectivePriceOre är priset som faktiskt betalas: totalkostnad om den är beräknad, annars spotpris
+func effectivePriceOre(p models.Price) float64 {
+	if p.TotalOre != nil {
+		return float64(*p.TotalOre)
+	}
+	return float64(p.PriceOre)
+}
+
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
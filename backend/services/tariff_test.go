@@ -0,0 +1,56 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+func TestTariffOreAt(t *testing.T) {
+	hour := func(h int) *int { return &h }
+	components := []models.TariffComponent{
+		{Name: "Energiskatt", Kind: "energy_tax", Type: models.TariffFlat, OrePerKWh: 40, Enabled: true},
+		{Name: "Höglast", Kind: "grid_fee", Type: models.TariffTimeOfUse, OrePerKWh: 50, StartHour: hour(6), EndHour: hour(22),
+			Weekdays: []int{1, 2, 3, 4, 5}, Months: []int{11, 12, 1, 2, 3}, Enabled: true},
+		{Name: "Natt", Kind: "grid_fee", Type: models.TariffTimeOfUse, OrePerKWh: 20, StartHour: hour(22), EndHour: hour(6), Enabled: true},
+		{Name: "Påslag", Kind: "markup", Type: models.TariffFlat, OrePerKWh: 100, Enabled: false},
+	}
+	at := func(month time.Month, day, h int) time.Time {
+		return time.Date(2025, month, day, h, 0, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want float64
+	}{
+		{"winter weekday daytime", at(time.January, 15, 12), 90},
+		{"start of the daytime band", at(time.January, 15, 6), 90},
+		{"last daytime hour", at(time.January, 15, 21), 90},
+		{"night band starts at 22", at(time.January, 15, 22), 60},
+		{"night band over midnight", at(time.January, 16, 3), 60},
+		{"winter weekend daytime", at(time.January, 18, 12), 40},
+		{"summer weekday daytime", at(time.July, 15, 12), 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TariffOreAt(components, tt.t); got != tt.want {
+				t.Errorf("got %v öre, want %v", got, tt.want)
+			}
+		})
+	}
+
+	prices := ApplyTariff([]models.Price{
+		{Timestamp: at(time.January, 15, 12), PriceOre: 100},
+		{Timestamp: at(time.January, 15, 23), PriceOre: 100},
+	}, components)
+	for i, want := range []int{213, 175} { // Spotpris + tariff inkl 25% moms
+		if prices[i].TotalOre == nil || *prices[i].TotalOre != want {
+			t.Errorf("total price %d = %v, want %d", i, prices[i].TotalOre, want)
+		}
+		if got := effectivePriceOre(prices[i]); got != float64(want) {
+			t.Errorf("effective price %d = %v, want %d", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+// ValidateScheduleTemplate kontrollerar att en schemamall är komplett och att posterna inte överlappar
+func ValidateScheduleTemplate(t models.ScheduleTemplate) error {
+	if t.Name == "" {
+		return fmt.Errorf("namn saknas")
+	}
+	for _, d := range t.Weekdays {
+		if d < 1 || d > 7 {
+			return fmt.Errorf("ogiltig veckodag %d (1=måndag ... 7=söndag)", d)
+		}
+	}
+	if len(t.Entries) == 0 {
+		return fmt.Errorf("mallen saknar poster")
+	}
+
+	var used [2 * 24 * 4]bool // Kvartar från mallens dygn och in i nästa (poster över midnatt)
+	for i, e := range t.Entries {
+		if _, ok := models.ModeDescriptions[e.Mode]; !ok {
+			return fmt.Errorf("post %d: ogiltigt läge %d", i+1, e.Mode)
+		}
+		start, err := parseClock(e.Start)
+		if err != nil {
+			return fmt.Errorf("post %d: %w", i+1, err)
+		}
+		end, err := parseClock(e.End)
+		if err != nil {
+			return fmt.Errorf("post %d: %w", i+1, err)
+		}
+		if start == 24*60 {
+			return fmt.Errorf("post %d: start kan inte vara 24:00", i+1)
+		}
+		if start == end {
+			return fmt.Errorf("post %d: start och slut är samma tid", i+1)
+		}
+		if end < start {
+			end += 24 * 60
+		}
+		for q := start / 15; q < end/15; q++ {
+			// En post över midnatt får inte heller krocka med dygnets början nästa dag
+			if used[q] || (q >= 96 && used[q-96]) || (q < 96 && used[q+96]) {
+				return fmt.Errorf("post %d överlappar en annan post", i+1)
+			}
+			used[q] = true
+		}
+	}
+
+	return nil
+}
+
+// TemplateModeAt returnerar mallarnas läge för kvarten som börjar vid t (lokal tid), eller false om
+// ingen mall gäller. Mallar för vissa veckodagar går före mallar för alla dagar, därefter i ID-ordning.
+// En post över midnatt hör till veckodagen då den börjar.
+func TemplateModeAt(templates []models.ScheduleTemplate, t time.Time) (int, bool) {
+	t = t.In(time.Local)
+	minute := t.Hour()*60 + t.Minute()
+	weekday := isoWeekday(t)
+	yesterday := isoWeekday(t.AddDate(0, 0, -1))
+
+	for _, weekly := range []bool{true, false} {
+		for _, tpl := range templates {
+			if !tpl.Enabled || (len(tpl.Weekdays) > 0) != weekly {
+				continue
+			}
+			for _, e := range tpl.Entries {
+				start, err1 := parseClock(e.Start)
+				end, err2 := parseClock(e.End)
+				if err1 != nil || err2 != nil {
+					continue
+				}
+				if start < end {
+					if minute >= start && minute < end && templateOnDay(tpl, weekday) {
+						return e.Mode, true
+					}
+					continue
+				}
+				if minute >= start && templateOnDay(tpl, weekday) {
+					return e.Mode, true
+				}
+				if minute < end && templateOnDay(tpl, yesterday) {
+					return e.Mode, true
+				}
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// ExpandTemplates räknar fram läge per kvart i times när mallarna läggs ut på befintligt schema.
+// Konkreta lägen går före mallen: bara kvartar som är passiva utan att någon brytpunkt samma dygn
+// (lokal tid) gjort dem passiva får mallens läge. En kvart som satts till Passiv eller rensats är
+// alltså planerad. Returnerar lägena och antal kvartar som ändrades.
+func ExpandTemplates(templates []models.ScheduleTemplate, existing []models.ScheduleChange, times []time.Time) ([]int, int) {
+	modes := make([]int, len(times))
+	changed := 0
+	for i, t := range times {
+		modes[i] = ModeAt(existing, t)
+		if modes[i] != modePassive || plannedOnDay(existing, t) {
+			continue
+		}
+		if mode, ok := TemplateModeAt(templates, t); ok && mode != modePassive {
+			modes[i] = mode
+			changed++
+		}
+	}
+	return modes, changed
+}
+
+// plannedOnDay avgör om brytpunkten som gäller vid t ligger samma dygn (lokal tid) som t
+func plannedOnDay(schedule []models.ScheduleChange, t time.Time) bool {
+	i := lastAtOrBefore(schedule, t)
+	if i < 0 {
+		return false
+	}
+	local := t.In(time.Local)
+	startOfDay := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local)
+	return !schedule[i].Timestamp.Before(startOfDay)
+}
+
+func templateOnDay(t models.ScheduleTemplate, weekday int) bool {
+	return len(t.Weekdays) == 0 || containsInt(t.Weekdays, weekday)
+}
+
+// isoWeekday returnerar veckodag 1=måndag ... 7=söndag
+func isoWeekday(t time.Time) int {
+	weekday := int(t.Weekday())
+	if weekday == 0 {
+		weekday = 7 // Söndag
+	}
+	return weekday
+}
+
+// parseClock tolkar "HH:MM" på hel kvart till minuter efter midnatt (0-1440)
+func parseClock(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("ogiltig tid %q (HH:MM)", value)
+	}
+	hour, err1 := strconv.Atoi(parts[0])
+	minute, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("ogiltig tid %q (HH:MM)", value)
+	}
+	if minute%15 != 0 {
+		return 0, fmt.Errorf("tiden %q ligger inte på hel kvart", value)
+	}
+	return hour*60 + minute, nil
+}
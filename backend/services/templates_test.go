@@ -0,0 +1,72 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+func TestExpandTemplatesKeepsPlannedQuarters(t *testing.T) {
+	day := time.Date(2025, 10, 6, 0, 0, 0, 0, time.Local)
+	quarter := func(hour, minute int) time.Time {
+		return day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+	}
+	templates := []models.ScheduleTemplate{{
+		Name:    "Kvällstopp",
+		Enabled: true,
+		Entries: []models.ScheduleTemplateEntry{{Start: "17:00", End: "18:00", Mode: 3}},
+	}}
+	times := []time.Time{quarter(17, 0), quarter(17, 15), quarter(17, 30), quarter(17, 45)}
+
+	tests := []struct {
+		name     string
+		existing []models.ScheduleChange
+		want     []int
+		changed  int
+	}{
+		{
+			name:    "empty schedule gets the template",
+			want:    []int{3, 3, 3, 3},
+			changed: 4,
+		},
+		{
+			name:     "passive carried over from an earlier day gets the template",
+			existing: []models.ScheduleChange{{Timestamp: day.AddDate(0, 0, -2), Mode: 1}},
+			want:     []int{3, 3, 3, 3},
+			changed:  4,
+		},
+		{
+			name: "quarters set to passive are kept",
+			existing: []models.ScheduleChange{
+				{Timestamp: quarter(17, 0), Mode: 1},
+				{Timestamp: quarter(17, 30), Mode: 1},
+			},
+			want: []int{1, 1, 1, 1},
+		},
+		{
+			name: "cleared quarters are kept",
+			existing: []models.ScheduleChange{
+				{Timestamp: day.AddDate(0, 0, -1), Mode: 2},
+				{Timestamp: quarter(17, 30), Mode: 1},
+			},
+			want: []int{2, 2, 1, 1},
+		},
+		{
+			name: "concrete modes are kept",
+			existing: []models.ScheduleChange{
+				{Timestamp: day.AddDate(0, 0, -1).Add(22 * time.Hour), Mode: 2},
+			},
+			want: []int{2, 2, 2, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modes, changed := ExpandTemplates(templates, tt.existing, times)
+			if !reflect.DeepEqual(modes, tt.want) || changed != tt.changed {
+				t.Errorf("got %v (%d changed), want %v (%d changed)", modes, changed, tt.want, tt.changed)
+			}
+		})
+	}
+}
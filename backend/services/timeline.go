@@ -0,0 +1,192 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+// ModeAt returnerar läget som gäller vid t i ett tidssorterat schema (default Passiv)
+func ModeAt(schedule []models.ScheduleChange, t time.Time) int {
+	if i := lastAtOrBefore(schedule, t); i >= 0 {
+		return schedule[i].Mode
+	}
+	return modePassive
+}
+
+// lastAtOrBefore returnerar index för sista brytpunkten vid eller före t i ett sorterat schema, -1 om ingen finns
+func lastAtOrBefore(schedule []models.ScheduleChange, t time.Time) int {
+	return sort.Search(len(schedule), func(i int) bool {
+		return schedule[i].Timestamp.After(t)
+	}) - 1
+}
+
+// ScheduleBetween returnerar brytpunkterna i [from, to) i ett sorterat schema, och före dem
+// brytpunkten som gäller vid from så att läget i hela intervallet framgår. Nolltid = öppet intervall.
+func ScheduleBetween(schedule []models.ScheduleChange, from, to time.Time) []models.ScheduleChange {
+	start := 0
+	if !from.IsZero() {
+		start = sort.Search(len(schedule), func(i int) bool {
+			return !schedule[i].Timestamp.Before(from)
+		})
+		if start > 0 && (start == len(schedule) || !schedule[start].Timestamp.Equal(from)) {
+			start-- // Brytpunkten som gäller vid from
+		}
+	}
+	end := len(schedule)
+	if !to.IsZero() {
+		end = sort.Search(len(schedule), func(i int) bool {
+			return !schedule[i].Timestamp.Before(to)
+		})
+	}
+	if start >= end {
+		return []models.ScheduleChange{}
+	}
+	return schedule[start:end]
+}
+
+// PruneSchedule tar bort brytpunkter före cutoff ur ett sorterat schema. Sista brytpunkten vid
+// eller före cutoff behålls, så att läget efter cutoff (och därmed nu) inte ändras.
+// Returnerar kvarvarande och borttagna brytpunkter.
+func PruneSchedule(schedule []models.ScheduleChange, cutoff time.Time) ([]models.ScheduleChange, []models.ScheduleChange) {
+	last := lastAtOrBefore(schedule, cutoff)
+	if last <= 0 {
+		return schedule, nil
+	}
+	return schedule[last:], schedule[:last]
+}
+
+// ModesToSchedule komprimerar ett läge per kvart till breakpoints (bara vid lägesändringar)
+func ModesToSchedule(times []time.Time, modes []int) []models.ScheduleChange {
+	var schedule []models.ScheduleChange
+	prevMode := 0
+	for i, mode := range modes {
+		if mode != prevMode {
+			schedule = append(schedule, models.ScheduleChange{
+				Timestamp: times[i],
+				Mode:      mode,
+			})
+			prevMode = mode
+		}
+	}
+	return schedule
+}
+
+// DiffSchedules jämför två scheman brytpunkt för brytpunkt
+func DiffSchedules(from, to []models.ScheduleChange) (added, removed []models.ScheduleChange, changed []models.ScheduleModeDiff) {
+	fromModes := make(map[int64]models.ScheduleChange, len(from))
+	for _, c := range from {
+		fromModes[c.Timestamp.Unix()] = c
+	}
+	toModes := make(map[int64]models.ScheduleChange, len(to))
+	for _, c := range to {
+		toModes[c.Timestamp.Unix()] = c
+	}
+
+	added = []models.ScheduleChange{}
+	removed = []models.ScheduleChange{}
+	changed = []models.ScheduleModeDiff{}
+
+	for _, c := range to {
+		prev, ok := fromModes[c.Timestamp.Unix()]
+		if !ok {
+			added = append(added, c)
+		} else if prev.Mode != c.Mode {
+			changed = append(changed, models.ScheduleModeDiff{Timestamp: c.Timestamp, FromMode: prev.Mode, ToMode: c.Mode})
+		}
+	}
+	for _, c := range from {
+		if _, ok := toModes[c.Timestamp.Unix()]; !ok {
+			removed = append(removed, c)
+		}
+	}
+
+	return added, removed, changed
+}
+
+// Intervalloperationer på schemat
+const (
+	ScheduleOpSet   = "set"   // Sätt ett läge i intervallet
+	ScheduleOpClear = "clear" // Gör intervallet passivt
+)
+
+// ScheduleOperation är en ändring av intervallet [From, To) i schemat
+type ScheduleOperation struct {
+	Op   string    `json:"op"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	Mode int       `json:"mode,omitempty"` // Bara för set
+}
+
+// ApplyScheduleOperation lägger in en intervalloperation i ett schema och returnerar ett nytt, sorterat schema.
+//
+// set följer samma regler som webbgränssnittets handleMouseUp: brytpunkter i intervallet tas bort,
+// läget läggs in vid From och Passiv vid To, utom när intervallet redan innehöll en
+// icke-passiv ändring (då fortsätter det nya läget förbi To). En befintlig brytpunkt vid To behålls.
+//
+// clear tar bort brytpunkterna i intervallet, sätter Passiv vid From och behåller läget som gällde vid To.
+func ApplyScheduleOperation(schedule []models.ScheduleChange, op ScheduleOperation) ([]models.ScheduleChange, error) {
+	if !op.From.Before(op.To) {
+		return nil, fmt.Errorf("from måste vara före to")
+	}
+	if !onQuarter(op.From) || !onQuarter(op.To) {
+		return nil, fmt.Errorf("from och to måste ligga på hel kvart")
+	}
+
+	sorted := make([]models.ScheduleChange, len(schedule))
+	copy(sorted, schedule)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	var kept []models.ScheduleChange
+	hasInnerChange := false
+	hasBreakpointAtEnd := false
+	for _, change := range sorted {
+		inside := !change.Timestamp.Before(op.From) && change.Timestamp.Before(op.To)
+		if inside {
+			if change.Mode != modePassive {
+				hasInnerChange = true
+			}
+			continue
+		}
+		if change.Timestamp.Equal(op.To) {
+			hasBreakpointAtEnd = true
+		}
+		kept = append(kept, change)
+	}
+
+	switch op.Op {
+	case ScheduleOpSet:
+		if _, ok := models.ModeDescriptions[op.Mode]; !ok {
+			return nil, fmt.Errorf("ogiltigt läge %d", op.Mode)
+		}
+		kept = append(kept, models.ScheduleChange{Timestamp: op.From, Mode: op.Mode})
+		if !hasInnerChange && !hasBreakpointAtEnd {
+			kept = append(kept, models.ScheduleChange{Timestamp: op.To, Mode: modePassive})
+		}
+
+	case ScheduleOpClear:
+		kept = append(kept, models.ScheduleChange{Timestamp: op.From, Mode: modePassive})
+		if !hasBreakpointAtEnd {
+			if mode := ModeAt(sorted, op.To); mode != modePassive {
+				kept = append(kept, models.ScheduleChange{Timestamp: op.To, Mode: mode})
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("okänd operation %q (set eller clear)", op.Op)
+	}
+
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].Timestamp.Before(kept[j].Timestamp)
+	})
+	return kept, nil
+}
+
+// onQuarter avgör om t ligger på en hel kvart
+func onQuarter(t time.Time) bool {
+	return t.Truncate(15 * time.Minute).Equal(t)
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+var timelineDay = time.Date(2025, 10, 6, 0, 0, 0, 0, time.UTC)
+
+func clock(hour, minute int) time.Time {
+	return timelineDay.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+}
+
+func TestApplyScheduleOperation(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule []models.ScheduleChange
+		op       ScheduleOperation
+		want     []models.ScheduleChange
+	}{
+		{
+			name:     "set on empty schedule ends with passive",
+			schedule: nil,
+			op:       ScheduleOperation{Op: ScheduleOpSet, From: clock(2, 0), To: clock(4, 0), Mode: 2},
+			want: []models.ScheduleChange{
+				{Timestamp: clock(2, 0), Mode: 2},
+				{Timestamp: clock(4, 0), Mode: 1},
+			},
+		},
+		{
+			name: "set replaces breakpoints inside the range",
+			schedule: []models.ScheduleChange{
+				{Timestamp: clock(1, 0), Mode: 3},
+				{Timestamp: clock(2, 30), Mode: 1},
+				{Timestamp: clock(6, 0), Mode: 3},
+			},
+			op: ScheduleOperation{Op: ScheduleOpSet, From: clock(2, 0), To: clock(4, 0), Mode: 2},
+			want: []models.ScheduleChange{
+				{Timestamp: clock(1, 0), Mode: 3},
+				{Timestamp: clock(2, 0), Mode: 2},
+				{Timestamp: clock(4, 0), Mode: 1},
+				{Timestamp: clock(6, 0), Mode: 3},
+			},
+		},
+		{
+			name: "set over a non-passive change continues past the end",
+			schedule: []models.ScheduleChange{
+				{Timestamp: clock(3, 0), Mode: 3},
+			},
+			op: ScheduleOperation{Op: ScheduleOpSet, From: clock(2, 0), To: clock(4, 0), Mode: 2},
+			want: []models.ScheduleChange{
+				{Timestamp: clock(2, 0), Mode: 2},
+			},
+		},
+		{
+			name: "set keeps an existing breakpoint at the end",
+			schedule: []models.ScheduleChange{
+				{Timestamp: clock(4, 0), Mode: 3},
+			},
+			op: ScheduleOperation{Op: ScheduleOpSet, From: clock(2, 0), To: clock(4, 0), Mode: 2},
+			want: []models.ScheduleChange{
+				{Timestamp: clock(2, 0), Mode: 2},
+				{Timestamp: clock(4, 0), Mode: 3},
+			},
+		},
+		{
+			name: "clear restores the mode at the end",
+			schedule: []models.ScheduleChange{
+				{Timestamp: clock(1, 0), Mode: 2},
+			},
+			op: ScheduleOperation{Op: ScheduleOpClear, From: clock(2, 0), To: clock(4, 0)},
+			want: []models.ScheduleChange{
+				{Timestamp: clock(1, 0), Mode: 2},
+				{Timestamp: clock(2, 0), Mode: 1},
+				{Timestamp: clock(4, 0), Mode: 2},
+			},
+		},
+		{
+			name: "clear restores a mode changed inside the range",
+			schedule: []models.ScheduleChange{
+				{Timestamp: clock(3, 0), Mode: 3},
+			},
+			op: ScheduleOperation{Op: ScheduleOpClear, From: clock(2, 0), To: clock(4, 0)},
+			want: []models.ScheduleChange{
+				{Timestamp: clock(2, 0), Mode: 1},
+				{Timestamp: clock(4, 0), Mode: 3},
+			},
+		},
+		{
+			name: "clear keeps an existing breakpoint at the end",
+			schedule: []models.ScheduleChange{
+				{Timestamp: clock(1, 0), Mode: 2},
+				{Timestamp: clock(4, 0), Mode: 1},
+			},
+			op: ScheduleOperation{Op: ScheduleOpClear, From: clock(2, 0), To: clock(4, 0)},
+			want: []models.ScheduleChange{
+				{Timestamp: clock(1, 0), Mode: 2},
+				{Timestamp: clock(2, 0), Mode: 1},
+				{Timestamp: clock(4, 0), Mode: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyScheduleOperation(tt.schedule, tt.op)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyScheduleOperationDoesNotModifyInput(t *testing.T) {
+	schedule := []models.ScheduleChange{
+		{Timestamp: clock(3, 0), Mode: 3},
+		{Timestamp: clock(1, 0), Mode: 2},
+	}
+	original := append([]models.ScheduleChange{}, schedule...)
+
+	if _, err := ApplyScheduleOperation(schedule, ScheduleOperation{Op: ScheduleOpSet, From: clock(2, 0), To: clock(4, 0), Mode: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(schedule, original) {
+		t.Errorf("input changed to %v", schedule)
+	}
+}
+
+func TestApplyScheduleOperationErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		op   ScheduleOperation
+	}{
+		{"empty range", ScheduleOperation{Op: ScheduleOpSet, From: clock(2, 0), To: clock(2, 0), Mode: 2}},
+		{"reversed range", ScheduleOperation{Op: ScheduleOpClear, From: clock(4, 0), To: clock(2, 0)}},
+		{"not on quarter", ScheduleOperation{Op: ScheduleOpSet, From: clock(2, 5), To: clock(4, 0), Mode: 2}},
+		{"unknown mode", ScheduleOperation{Op: ScheduleOpSet, From: clock(2, 0), To: clock(4, 0), Mode: 7}},
+		{"unknown op", ScheduleOperation{Op: "toggle", From: clock(2, 0), To: clock(4, 0)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ApplyScheduleOperation(nil, tt.op); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
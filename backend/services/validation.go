@@ -0,0 +1,157 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"battery-scheduler/models"
+)
+
+// Regler i schemavalideringen
+const (
+	RuleModeRange        = "mode_range"              // Okänt läge
+	RuleQuarterAlignment = "quarter_alignment"       // Brytpunkten ligger inte på hel kvart
+	RuleDuplicate        = "duplicate_timestamp"     // Flera brytpunkter vid samma tid
+	RuleUnsorted         = "unsorted"                // Brytpunkterna skickades inte i tidsordning
+	RulePastEdit         = "past_edit"               // Ny eller ändrad brytpunkt före aktuell kvart
+	RuleChargeFull       = "charge_full"             // Laddning när simulerat batteri redan är fullt
+	RuleDischargeMinSoC  = "discharge_below_min_soc" // Urladdning när simulerat batteri är nere på min_soc
+)
+
+// Allvarlighetsgrader för regelbrott
+const (
+	SeverityError   = "error"   // Schemat sparas inte
+	SeverityWarning = "warning" // Schemat sparas men gör troligen inte det som avsågs
+)
+
+// ScheduleValidationError innehåller alla regelbrott som hindrar att ett schema sparas
+type ScheduleValidationError struct {
+	Violations []models.ScheduleViolation
+}
+
+func (e *ScheduleValidationError) Error() string {
+	var errs []string
+	for _, v := range e.Violations {
+		if v.Severity == SeverityError {
+			errs = append(errs, v.Message)
+		}
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return fmt.Sprintf("%d fel i schemat: %s", len(errs), strings.Join(errs, "; "))
+}
+
+// ValidateSchedule kontrollerar ett nytt schema mot reglerna som inte kräver simulering.
+// current är aktivt schema, som brytpunkter före aktuell kvart jämförs med.
+// Returnerar *ScheduleValidationError om något regelbrott är ett fel.
+func ValidateSchedule(schedule, current []models.ScheduleChange, now time.Time) error {
+	violations := CheckSchedule(schedule, current, now)
+	if HasScheduleErrors(violations) {
+		return &ScheduleValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// CheckSchedule går igenom läge, kvartsgrid, dubbletter, ordning och ändringar bakåt i tiden
+// och returnerar alla regelbrott sorterade på tid
+func CheckSchedule(schedule, current []models.ScheduleChange, now time.Time) []models.ScheduleViolation {
+	var violations []models.ScheduleViolation
+	add := func(rule, severity string, change models.ScheduleChange, format string, args ...interface{}) {
+		violations = append(violations, models.ScheduleViolation{
+			Rule:      rule,
+			Severity:  severity,
+			Timestamp: change.Timestamp,
+			Mode:      change.Mode,
+			Message:   fmt.Sprintf("%s: %s", formatViolationTime(change.Timestamp), fmt.Sprintf(format, args...)),
+		})
+	}
+
+	currentQuarter := now.Truncate(15 * time.Minute)
+	pastModes := make(map[int64]int)
+	for _, change := range current {
+		if change.Timestamp.Before(currentQuarter) {
+			pastModes[change.Timestamp.UnixNano()] = change.Mode
+		}
+	}
+
+	for i, change := range schedule {
+		if _, ok := models.ModeDescriptions[change.Mode]; !ok {
+			add(RuleModeRange, SeverityError, change, "okänt läge %d (1-6)", change.Mode)
+		}
+		if !onQuarter(change.Timestamp) {
+			add(RuleQuarterAlignment, SeverityError, change, "brytpunkten ligger inte på hel kvart")
+		}
+		if i > 0 && change.Timestamp.Before(schedule[i-1].Timestamp) {
+			add(RuleUnsorted, SeverityWarning, change, "brytpunkten kommer efter en senare brytpunkt, schemat sorteras")
+		}
+		if change.Timestamp.Before(currentQuarter) {
+			if mode, ok := pastModes[change.Timestamp.UnixNano()]; !ok || mode != change.Mode {
+				add(RulePastEdit, SeverityError, change, "brytpunkter före aktuell kvart kan inte läggas till eller ändras")
+			}
+		}
+	}
+
+	sorted := make([]models.ScheduleChange, len(schedule))
+	copy(sorted, schedule)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Timestamp.Equal(sorted[i-1].Timestamp) {
+			add(RuleDuplicate, SeverityError, sorted[i], "flera brytpunkter vid samma tid (lägen %d och %d)", sorted[i-1].Mode, sorted[i].Mode)
+		}
+	}
+
+	sortViolations(violations)
+	return violations
+}
+
+// CheckSimulation hittar laddning när batteriet redan är fullt och urladdning när det är nere
+// på min_soc i en simulering av schemat. Båda är varningar, eftersom de bygger på prognoser.
+func (b *BatterySimulator) CheckSimulation(points []models.SimulationPoint) []models.ScheduleViolation {
+	var violations []models.ScheduleViolation
+	for _, p := range points {
+		switch {
+		case p.Mode == modeCharge && p.SoC >= 100:
+			violations = append(violations, models.ScheduleViolation{
+				Rule:      RuleChargeFull,
+				Severity:  SeverityWarning,
+				Timestamp: p.Timestamp,
+				Mode:      p.Mode,
+				Message:   fmt.Sprintf("%s: batteriet beräknas redan vara fullt vid laddning", formatViolationTime(p.Timestamp)),
+			})
+		case p.Mode == modeDischarge && p.SoC <= b.MinSoC:
+			violations = append(violations, models.ScheduleViolation{
+				Rule:      RuleDischargeMinSoC,
+				Severity:  SeverityWarning,
+				Timestamp: p.Timestamp,
+				Mode:      p.Mode,
+				Message:   fmt.Sprintf("%s: batteriet beräknas vara nere på %.0f%% vid urladdning", formatViolationTime(p.Timestamp), b.MinSoC),
+			})
+		}
+	}
+	return violations
+}
+
+// HasScheduleErrors avgör om något regelbrott hindrar att schemat sparas
+func HasScheduleErrors(violations []models.ScheduleViolation) bool {
+	for _, v := range violations {
+		if v.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func sortViolations(violations []models.ScheduleViolation) {
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Timestamp.Before(violations[j].Timestamp)
+	})
+}
+
+func formatViolationTime(t time.Time) string {
+	return t.In(time.Local).Format("2006-01-02 15:04")
+}
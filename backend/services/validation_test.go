@@ -0,0 +1,195 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"battery-scheduler/models"
+)
+
+// validationNow ligger mitt i kvarten 12:00, så att brytpunkter före 12:00 räknas som bakåt i tiden
+var validationNow = clock(12, 7)
+
+func violationRules(violations []models.ScheduleViolation) map[string]string {
+	rules := make(map[string]string)
+	for _, v := range violations {
+		rules[v.Rule] = v.Severity
+	}
+	return rules
+}
+
+func TestCheckScheduleRules(t *testing.T) {
+	current := []models.ScheduleChange{
+		{Timestamp: clock(10, 0), Mode: 2},
+		{Timestamp: clock(11, 0), Mode: 1},
+	}
+
+	tests := []struct {
+		name     string
+		rule     string
+		severity string
+		valid    []models.ScheduleChange
+		invalid  []models.ScheduleChange
+	}{
+		{
+			name:     "mode range",
+			rule:     RuleModeRange,
+			severity: SeverityError,
+			valid:    []models.ScheduleChange{{Timestamp: clock(13, 0), Mode: 6}},
+			invalid:  []models.ScheduleChange{{Timestamp: clock(13, 0), Mode: 7}},
+		},
+		{
+			name:     "quarter alignment",
+			rule:     RuleQuarterAlignment,
+			severity: SeverityError,
+			valid:    []models.ScheduleChange{{Timestamp: clock(13, 45), Mode: 2}},
+			invalid:  []models.ScheduleChange{{Timestamp: clock(13, 50), Mode: 2}},
+		},
+		{
+			name:     "duplicate timestamp",
+			rule:     RuleDuplicate,
+			severity: SeverityError,
+			valid: []models.ScheduleChange{
+				{Timestamp: clock(13, 0), Mode: 2},
+				{Timestamp: clock(13, 15), Mode: 3},
+			},
+			invalid: []models.ScheduleChange{
+				{Timestamp: clock(13, 0), Mode: 2},
+				{Timestamp: clock(13, 0), Mode: 3},
+			},
+		},
+		{
+			name:     "unsorted",
+			rule:     RuleUnsorted,
+			severity: SeverityWarning,
+			valid: []models.ScheduleChange{
+				{Timestamp: clock(13, 0), Mode: 2},
+				{Timestamp: clock(14, 0), Mode: 1},
+			},
+			invalid: []models.ScheduleChange{
+				{Timestamp: clock(14, 0), Mode: 1},
+				{Timestamp: clock(13, 0), Mode: 2},
+			},
+		},
+		{
+			name:     "past edit",
+			rule:     RulePastEdit,
+			severity: SeverityError,
+			valid: []models.ScheduleChange{
+				{Timestamp: clock(10, 0), Mode: 2},
+				{Timestamp: clock(11, 0), Mode: 1},
+				{Timestamp: clock(12, 0), Mode: 3},
+			},
+			invalid: []models.ScheduleChange{
+				{Timestamp: clock(10, 0), Mode: 3},
+				{Timestamp: clock(11, 0), Mode: 1},
+			},
+		},
+		{
+			name:     "past edit adding a breakpoint",
+			rule:     RulePastEdit,
+			severity: SeverityError,
+			valid:    current,
+			invalid: []models.ScheduleChange{
+				{Timestamp: clock(10, 0), Mode: 2},
+				{Timestamp: clock(10, 30), Mode: 3},
+				{Timestamp: clock(11, 0), Mode: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CheckSchedule(tt.valid, current, validationNow); len(got) != 0 {
+				t.Errorf("valid schedule got violations %v", got)
+			}
+			rules := violationRules(CheckSchedule(tt.invalid, current, validationNow))
+			if severity, ok := rules[tt.rule]; !ok || severity != tt.severity {
+				t.Errorf("invalid schedule got %v, want %s (%s)", rules, tt.rule, tt.severity)
+			}
+		})
+	}
+}
+
+func TestCheckSimulationRules(t *testing.T) {
+	battery := NewBatterySimulator(10, 15)
+
+	tests := []struct {
+		name    string
+		rule    string
+		valid   models.SimulationPoint
+		invalid models.SimulationPoint
+	}{
+		{
+			name:    "charge full",
+			rule:    RuleChargeFull,
+			valid:   models.SimulationPoint{Timestamp: clock(13, 0), Mode: modeCharge, SoC: 99},
+			invalid: models.SimulationPoint{Timestamp: clock(13, 0), Mode: modeCharge, SoC: 100},
+		},
+		{
+			name:    "discharge below min soc",
+			rule:    RuleDischargeMinSoC,
+			valid:   models.SimulationPoint{Timestamp: clock(13, 0), Mode: modeDischarge, SoC: 16},
+			invalid: models.SimulationPoint{Timestamp: clock(13, 0), Mode: modeDischarge, SoC: 15},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := battery.CheckSimulation([]models.SimulationPoint{tt.valid}); len(got) != 0 {
+				t.Errorf("valid simulation got violations %v", got)
+			}
+			rules := violationRules(battery.CheckSimulation([]models.SimulationPoint{tt.invalid}))
+			if severity, ok := rules[tt.rule]; !ok || severity != SeverityWarning {
+				t.Errorf("invalid simulation got %v, want %s (warning)", rules, tt.rule)
+			}
+		})
+	}
+}
+
+func TestHasScheduleErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		violations []models.ScheduleViolation
+		want       bool
+	}{
+		{"none", nil, false},
+		{"only warnings", []models.ScheduleViolation{{Rule: RuleUnsorted, Severity: SeverityWarning}}, false},
+		{"an error", []models.ScheduleViolation{
+			{Rule: RuleUnsorted, Severity: SeverityWarning},
+			{Rule: RuleModeRange, Severity: SeverityError},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasScheduleErrors(tt.violations); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	unsorted := []models.ScheduleChange{
+		{Timestamp: clock(14, 0), Mode: 1},
+		{Timestamp: clock(13, 0), Mode: 2},
+	}
+	if err := ValidateSchedule(unsorted, nil, validationNow); err != nil {
+		t.Errorf("warnings only: got error %v", err)
+	}
+
+	invalid := []models.ScheduleChange{
+		{Timestamp: clock(13, 0), Mode: 9},
+		{Timestamp: clock(13, 10), Mode: 2},
+	}
+	err := ValidateSchedule(invalid, nil, validationNow)
+	var validationErr *ScheduleValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("error = %v, want *ScheduleValidationError", err)
+	}
+	rules := violationRules(validationErr.Violations)
+	if _, ok := rules[RuleModeRange]; !ok {
+		t.Errorf("violations %v missing %s", rules, RuleModeRange)
+	}
+	if _, ok := rules[RuleQuarterAlignment]; !ok {
+		t.Errorf("violations %v missing %s", rules, RuleQuarterAlignment)
+	}
+}
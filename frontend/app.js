@@ -12,20 +12,8 @@ const MODES = [
   { id: 6, name: 'Laddbox U', color: 'bg-purple-500', textColor: 'text-white', desc: 'Ute' }
 ];
 
-const BATTERY_CAPACITY = 42;
-const MIN_SOC = 15;
 
-// Laddkurva approximerad från bilden
-const getChargePower = (soc) => {
-  if (soc < 15) return 0;
-  if (soc <= 80) return -10.0;
-  if (soc <= 90) return -10.0 + (soc - 80) * (5.0 / 10);
-  if (soc <= 95) return -5.0 + (soc - 90) * (3.0 / 5);
-  return -2.0;
-};
-
-
-function BatteryScheduler() {
+function BatteryScheduler({ principal }) {
   const [prices, setPrices] = useState([]);
   const [consumption, setConsumption] = useState([]);
   const [schedule, setSchedule] = useState([]);
@@ -33,8 +21,11 @@ function BatteryScheduler() {
   const [priceYMax, setPriceYMax] = useState(400);
   const [priceDiffD, setPriceDiffD] = useState(50);
   const [currentSoC, setCurrentSoC] = useState(50);
+  const [simulation, setSimulation] = useState([]);
   const [loading, setLoading] = useState(true);
   const [saving, setSaving] = useState(false);
+  const scheduleETagRef = useRef(null);
+  const scheduleFromRef = useRef(null); // Början på det hämtade schemat, tidigare brytpunkter behålls vid sparning
   
   const [isDragging, setIsDragging] = useState(false);
   const [draggedQuarters, setDraggedQuarters] = useState(new Set());
@@ -56,7 +47,11 @@ function BatteryScheduler() {
         })));
         
         // Hämta schedule
-        const scheduleRes = await fetch(`${API_BASE}/schedule`);
+        const startOfToday = new Date();
+        startOfToday.setHours(0, 0, 0, 0);
+        scheduleFromRef.current = startOfToday.toISOString();
+        const scheduleRes = await fetch(`${API_BASE}/schedule?from=${encodeURIComponent(scheduleFromRef.current)}`);
+        scheduleETagRef.current = scheduleRes.headers.get('ETag');
         const scheduleData = await scheduleRes.json();
         setSchedule(scheduleData.map(s => ({
           ...s,
@@ -83,18 +78,15 @@ function BatteryScheduler() {
     
     loadData();
     
-    // Uppdatera SoC var 30:e sekund (fångar solpanelsladdning snabbare)
-    const interval = setInterval(async () => {
-      try {
-        const socRes = await fetch(`${API_BASE}/battery-soc`);
-        const socData = await socRes.json();
-        setCurrentSoC(socData.percentage);
-      } catch (error) {
-        console.error('Failed to update SoC:', error);
-      }
-    }, 30000);
+    // Ta emot nya SoC-värden direkt från servern (fångar solpanelsladdning snabbare)
+    const events = new EventSource(`${API_BASE}/events`);
+    events.addEventListener('soc', (e) => {
+      const event = JSON.parse(e.data);
+      setCurrentSoC(event.data.percentage);
+    });
+    events.onerror = () => console.error('Event stream interrupted, reconnecting');
     
-    return () => clearInterval(interval);
+    return () => events.close();
   }, []);
   
   const getModeForQuarter = (quarterIndex) => {
@@ -122,40 +114,44 @@ function BatteryScheduler() {
     return 0;
   }, [prices]);
 
-  // Simulera batterinivå: använd riktig SoC vid aktuell tidpunkt, simulera framåt
-  const simulateBatterySoC = useMemo(() => {
-    const result = new Array(prices.length).fill(null);
-    if (prices.length === 0) return result;
-
-    // Sätt riktig SoC vid aktuell kvart
-    result[currentQuarterIndex] = currentSoC;
-
-    // Simulera framåt från nu
-    let soc = currentSoC;
-    for (let i = currentQuarterIndex + 1; i < prices.length; i++) {
-      const mode = getModeForQuarter(i);
-      const consumptionKw = consumption[i] || 1.0;
-      const quarterHours = 0.25;
-
-      let deltaEnergy = 0;
-
-      if (mode === 2) {
-        const chargePower = getChargePower(soc);
-        deltaEnergy = chargePower * quarterHours;
-      } else if (mode === 3) {
-        deltaEnergy = consumptionKw * quarterHours;
-      } else if (mode === 5 || mode === 6) {
-        deltaEnergy = 0;
+  // Simulera batterinivå i backend (samma modell som optimeraren) för schemat som visas,
+  // även osparade ändringar. Debounce så att drag inte ger en request per kvart.
+  useEffect(() => {
+    if (prices.length === 0) return;
+    const controller = new AbortController();
+    const timer = setTimeout(async () => {
+      try {
+        const res = await fetch(`${API_BASE}/simulation`, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({
+            schedule: schedule.map(s => ({ timestamp: s.timestamp.toISOString(), mode: s.mode })),
+            start_soc: currentSoC
+          }),
+          signal: controller.signal
+        });
+        if (!res.ok) throw new Error(`status ${res.status}`);
+        setSimulation(await res.json());
+      } catch (error) {
+        if (error.name !== 'AbortError') console.error('Failed to simulate SoC:', error);
       }
+    }, 200);
+    return () => {
+      clearTimeout(timer);
+      controller.abort();
+    };
+  }, [schedule, currentSoC, prices]);
 
-      const deltaSoC = (deltaEnergy / BATTERY_CAPACITY) * 100;
-      soc = Math.max(MIN_SOC, Math.min(100, soc - deltaSoC));
-
-      result[i] = Number(soc.toFixed(1));
+  // SoC per kvartsindex (null före aktuell kvart och där simulering saknas)
+  const simulateBatterySoC = useMemo(() => {
+    const result = new Array(prices.length).fill(null);
+    const socByTime = new Map(simulation.map(p => [new Date(p.timestamp).getTime(), p.soc]));
+    for (let i = currentQuarterIndex; i < prices.length; i++) {
+      const soc = socByTime.get(prices[i].timestamp.getTime());
+      if (soc !== undefined) result[i] = soc;
     }
-
     return result;
-  }, [schedule, currentSoC, consumption, prices, currentQuarterIndex]);
+  }, [simulation, prices, currentQuarterIndex]);
   
   // Summering
   const summary = useMemo(() => {
@@ -384,21 +380,45 @@ function BatteryScheduler() {
   const handleSave = async () => {
     setSaving(true);
     try {
-      const response = await fetch(`${API_BASE}/schedule`, {
+      const headers = {
+        'Content-Type': 'application/json',
+        'X-Schedule-Source': 'ui',
+      };
+      if (scheduleETagRef.current) {
+        headers['If-Match'] = scheduleETagRef.current;
+      }
+      const query = scheduleFromRef.current ? `?from=${encodeURIComponent(scheduleFromRef.current)}` : '';
+      const response = await fetch(`${API_BASE}/schedule${query}`, {
         method: 'POST',
-        headers: {
-          'Content-Type': 'application/json',
-        },
+        headers,
         body: JSON.stringify(schedule.map(s => ({
           timestamp: s.timestamp.toISOString(),
           mode: s.mode
         })))
       });
       
+      if (response.status === 401 || response.status === 403) {
+        const body = await response.json();
+        alert(`Schemat sparades inte: ${body.error}`);
+        return;
+      }
+      if (response.status === 412) {
+        alert('Schemat har ändrats av någon annan sedan sidan laddades. Ladda om sidan och gör om ändringen.');
+        return;
+      }
+      if (response.status === 400) {
+        const body = await response.json();
+        alert(`Schemat sparades inte:\n${(body.violations || [])
+          .filter(v => v.severity === 'error')
+          .map(v => v.message)
+          .join('\n') || body.error}`);
+        return;
+      }
       if (!response.ok) {
         throw new Error('Failed to save schedule');
       }
       
+      scheduleETagRef.current = response.headers.get('ETag');
       alert('Schema sparat!');
     } catch (error) {
       console.error('Failed to save:', error);
@@ -444,6 +464,20 @@ function BatteryScheduler() {
               <div className="text-sm text-gray-600">Aktuell laddnivå</div>
               <div className="text-2xl font-bold text-blue-600">{currentSoC}%</div>
             </div>
+            {principal && (
+              <div className="text-right text-sm text-gray-600">
+                <div>{principal.name} ({principal.role})</div>
+                <button
+                  className="text-blue-600 hover:underline"
+                  onClick={async () => {
+                    await fetch(`${API_BASE}/auth/logout`, { method: 'POST' });
+                    window.location.reload();
+                  }}
+                >
+                  Logga ut
+                </button>
+              </div>
+            )}
           </div>
           
           <div className="grid md:grid-cols-3 gap-4">
@@ -702,7 +736,7 @@ function BatteryScheduler() {
                     <rect
                       x={Math.min(toX(hoverIdx) + 8, 1060)}
                       y="15"
-                      width="180" height="80" rx="4"
+                      width="180" height="96" rx="4"
                       fill="white" stroke="#d1d5db" strokeWidth="1"
                       filter="drop-shadow(0 1px 2px rgba(0,0,0,0.1))"
                     />
@@ -712,10 +746,13 @@ function BatteryScheduler() {
                     <text x={Math.min(toX(hoverIdx) + 18, 1070)} y="51" fontSize="12" fill="#6b7280">
                       Pris: {prices[hoverIdx].price} öre/kWh
                     </text>
-                    <text x={Math.min(toX(hoverIdx) + 18, 1070)} y="67" fontSize="12" fill="#6366f1">
+                    <text x={Math.min(toX(hoverIdx) + 18, 1070)} y="67" fontSize="12" fill="#6b7280">
+                      Totalt: {prices[hoverIdx].total_price ?? '-'} öre/kWh
+                    </text>
+                    <text x={Math.min(toX(hoverIdx) + 18, 1070)} y="83" fontSize="12" fill="#6366f1">
                       Förbrukning: {consumption[hoverIdx] ? consumption[hoverIdx].toFixed(1) : '-'} kW
                     </text>
-                    <text x={Math.min(toX(hoverIdx) + 18, 1070)} y="83" fontSize="12" fill="#f59e0b">
+                    <text x={Math.min(toX(hoverIdx) + 18, 1070)} y="99" fontSize="12" fill="#f59e0b">
                       SoC: {simulateBatterySoC[hoverIdx] !== null ? `${simulateBatterySoC[hoverIdx]}%` : '-'}
                     </text>
                   </g>
@@ -919,6 +956,86 @@ function BatteryScheduler() {
   );
 }
 
+function LoginForm() {
+  const [username, setUsername] = useState('');
+  const [password, setPassword] = useState('');
+  const [error, setError] = useState(null);
+  
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setError(null);
+    try {
+      const response = await fetch(`${API_BASE}/auth/login`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ username, password })
+      });
+      if (!response.ok) {
+        const body = await response.json();
+        setError(body.error);
+        return;
+      }
+      window.location.reload();
+    } catch (error) {
+      console.error('Failed to log in:', error);
+      setError('Kunde inte nå servern');
+    }
+  };
+  
+  return (
+    <div className="min-h-screen bg-gray-50 flex items-center justify-center">
+      <form className="bg-white rounded-lg shadow p-6 w-80 space-y-3" onSubmit={handleSubmit}>
+        <div className="flex items-center gap-3">
+          <div className="text-2xl">🔋</div>
+          <h1 className="text-xl font-bold text-gray-800">Logga in</h1>
+        </div>
+        <input
+          className="w-full border rounded px-3 py-2"
+          placeholder="Användarnamn"
+          autoComplete="username"
+          value={username}
+          onChange={(e) => setUsername(e.target.value)}
+        />
+        <input
+          className="w-full border rounded px-3 py-2"
+          type="password"
+          placeholder="Lösenord"
+          autoComplete="current-password"
+          value={password}
+          onChange={(e) => setPassword(e.target.value)}
+        />
+        {error && <div className="text-sm text-red-600">{error}</div>}
+        <button className="w-full bg-blue-600 text-white rounded py-2 font-medium" type="submit">
+          Logga in
+        </button>
+      </form>
+    </div>
+  );
+}
+
+// Visa inloggning om backend kräver det
+function App() {
+  const [auth, setAuth] = useState(null);
+  
+  useEffect(() => {
+    fetch(`${API_BASE}/auth/me`)
+      .then(res => res.json())
+      .then(setAuth)
+      .catch(error => {
+        console.error('Failed to check login:', error);
+        setAuth({ enabled: false, principal: null });
+      });
+  }, []);
+  
+  if (!auth) {
+    return null;
+  }
+  if (auth.enabled && !auth.principal) {
+    return <LoginForm />;
+  }
+  return <BatteryScheduler principal={auth.principal} />;
+}
+
 // Rendera appen
 const root = ReactDOM.createRoot(document.getElementById('root'));
-root.render(<BatteryScheduler />);
\ No newline at end of file
+root.render(<App />);
\ No newline at end of file